@@ -0,0 +1,83 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+// Command rasterbench runs the standard rasterizer workloads and reports
+// their throughput.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/andreas-jonsson/drive/rasterizer"
+	"github.com/andreas-jonsson/drive/rasterizer/bench"
+)
+
+var (
+	frames    = flag.Int("frames", 100, "number of frames to draw per scene")
+	sceneName = flag.String("scene", "", "only run the named scene")
+	edge      = flag.Bool("edge", false, "use the edge function rasterizer")
+	spans     = flag.Bool("spans", false, "enable span buffer hidden surface removal")
+)
+
+func main() {
+	flag.Parse()
+
+	if *frames < 1 {
+		fmt.Fprintln(os.Stderr, "frames must be at least 1")
+		os.Exit(2)
+	}
+
+	var configs []rasterizer.Config
+	if *edge {
+		configs = append(configs, rasterizer.ConfigWithEdgeFunctions)
+	}
+	if *spans {
+		configs = append(configs, rasterizer.ConfigWithSpanBuffer)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "scene\tframe\ttris/s\tpixels/s\tgeometry/frame\traster/frame\t")
+
+	found := false
+	for _, scene := range bench.Scenes() {
+		if *sceneName != "" && scene.Name != *sceneName {
+			continue
+		}
+		found = true
+
+		r, err := rasterizer.NewRasterizer(bench.NewTarget(), configs...)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		// Warm up caches and lazily built tables.
+		bench.Run(r, scene, 1)
+		res := bench.Run(r, scene, *frames)
+		r.Destroy()
+
+		perFrame := func(d time.Duration) time.Duration {
+			return d / time.Duration(res.Frames)
+		}
+
+		fmt.Fprintf(w, "%s\t%v\t%.3gM\t%.3gM\t%v\t%v\t\n",
+			scene.Name,
+			res.FrameTime(),
+			res.TrianglesPerSecond()/1e6,
+			res.PixelsPerSecond()/1e6,
+			perFrame(res.Stats.GeometryTime),
+			perFrame(res.Stats.RasterTime))
+	}
+	w.Flush()
+
+	if !found {
+		fmt.Fprintf(os.Stderr, "unknown scene: %s\n", *sceneName)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,96 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+// Command rastreplay replays a rasterizer capture one draw call at a time
+// and writes the back buffer after every step as a PNG.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+
+	"github.com/andreas-jonsson/drive/rasterizer"
+)
+
+var (
+	outDir = flag.String("out", ".", "directory to write the PNG files to")
+	first  = flag.Int("first", 0, "first draw call to write")
+	last   = flag.Int("last", -1, "last draw call to write, -1 for all")
+	step   = flag.Bool("step", false, "wait for enter after each draw call")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] capture\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := replay(flag.Arg(0)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func replay(path string) error {
+	fp, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	capture, err := rasterizer.DecodeCapture(bufio.NewReader(fp))
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		return err
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+
+	return capture.Replay(func(i int, backBuffer *image.Paletted) error {
+		if i < *first || (*last >= 0 && i > *last) {
+			return nil
+		}
+
+		name := filepath.Join(*outDir, fmt.Sprintf("step%04d.png", i))
+		fmt.Printf("%d/%d: %s -> %s\n", i+1, capture.Len(), capture.Describe(i), name)
+
+		if err := writePNG(name, backBuffer); err != nil {
+			return err
+		}
+
+		if *step {
+			_, err := stdin.ReadString('\n')
+			return err
+		}
+		return nil
+	})
+}
+
+func writePNG(path string, img image.Image) error {
+	fp, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := png.Encode(fp, img); err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
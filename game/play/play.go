@@ -11,14 +11,17 @@ import (
 
 	"image/png"
 
+	"github.com/andreas-jonsson/drive/data"
 	"github.com/andreas-jonsson/drive/game"
 	"github.com/andreas-jonsson/drive/platform"
 	"github.com/andreas-jonsson/drive/rasterizer"
-	"github.com/andreas-jonsson/openwar/data"
+	"github.com/ungerik/go3d/mat4"
+	"github.com/ungerik/go3d/vec3"
 )
 
 type playState struct {
 	testImage *image.Paletted
+	rast      *rasterizer.Rasterizer
 }
 
 func NewPlayState() *playState {
@@ -33,7 +36,7 @@ func NewPlayState() *playState {
 		log.Panicln(err)
 	}
 
-	return &playState{img.(*image.Paletted)}
+	return &playState{testImage: img.(*image.Paletted)}
 }
 
 func (s *playState) Name() string {
@@ -45,6 +48,10 @@ func (s *playState) Enter(from game.GameState, args ...interface{}) error {
 }
 
 func (s *playState) Exit(to game.GameState) error {
+	if s.rast != nil {
+		s.rast.Destroy()
+		s.rast = nil
+	}
 	return nil
 }
 
@@ -59,12 +66,25 @@ func (s *playState) Update(gctl game.GameControl) error {
 }
 
 func (s *playState) Render(backBuffer *image.Paletted) error {
+	if s.rast == nil {
+		rast, err := rasterizer.NewRasterizer(backBuffer)
+		if err != nil {
+			return err
+		}
+		s.rast = rast
+	}
 
-	//ps := NewDefaultUVShader(backBuffer, s.testImage)
-	//rasterizer.RasterizeUV(ps, 10, 10, 500, 10, 10, 500, 0, 0, 1, 0, 0, 1)
-
-	ps := rasterizer.NewDefaultFlatShader(backBuffer)
-	rasterizer.RasterizeFlat(ps, 10, 10, 500, 10, 10, 500, 10)
+	// Map pixel coordinates to clip space.
+	size := backBuffer.Bounds().Size()
+	mvp := mat4.Ident
+	mvp[0][0] = 2 / float32(size.X)
+	mvp[1][1] = -2 / float32(size.Y)
+	mvp[3][0] = -1
+	mvp[3][1] = 1
+
+	vert := []vec3.T{{10, 10, 0}, {500, 10, 0}, {10, 500, 0}}
+	s.rast.DrawFlat(&mvp, vert, []uint8{10}, rasterizer.WithPixelShader(rasterizer.NewDefaultFlatShader()))
+	s.rast.Sync()
 
 	return nil
 }
@@ -0,0 +1,209 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+// Package bench holds standard rasterizer workloads shared by the
+// benchmarks and the rasterbench command.
+package bench
+
+import (
+	"image"
+	"image/color/palette"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/andreas-jonsson/drive/rasterizer"
+	"github.com/ungerik/go3d/mat4"
+	"github.com/ungerik/go3d/vec2"
+	"github.com/ungerik/go3d/vec3"
+)
+
+// Width and Height of the back buffer the scenes are designed for.
+const (
+	Width  = 320
+	Height = 200
+)
+
+// Scene is a workload that is drawn once per frame.
+type Scene struct {
+	Name        string
+	Description string
+	Draw        func(r *rasterizer.Rasterizer)
+}
+
+// Result is the outcome of running a scene for a number of frames.
+type Result struct {
+	Frames   int
+	Duration time.Duration
+	Stats    rasterizer.Stats
+}
+
+// Scenes returns the standard workloads. Geometry is generated from a fixed
+// seed so every run draws the same triangles.
+func Scenes() []Scene {
+	rnd := rand.New(rand.NewSource(1))
+	texture := checkerboard(64, 8)
+
+	tinyVert, tinyColors := randomTriangles(rnd, 20000, 3)
+	hugeVert, hugeColors := randomTriangles(rnd, 16, 1000)
+	mediumVert, mediumColors := randomTriangles(rnd, 2000, 40)
+	mediumUVs := randomUVs(rnd, len(mediumVert))
+
+	overdrawVert, overdrawColors := fullscreenLayers(32)
+
+	cone := fanMesh(12, 6)
+	cones := randomInstances(rnd, 500)
+
+	return []Scene{
+		{"tiny", "20000 triangles of a few pixels", func(r *rasterizer.Rasterizer) {
+			r.DrawFlat(pixelSpace(), tinyVert, tinyColors)
+		}},
+		{"huge", "16 triangles spanning the whole screen", func(r *rasterizer.Rasterizer) {
+			r.DrawFlat(pixelSpace(), hugeVert, hugeColors)
+		}},
+		{"flat", "2000 flat shaded triangles of medium size", func(r *rasterizer.Rasterizer) {
+			r.DrawFlat(pixelSpace(), mediumVert, mediumColors)
+		}},
+		{"textured", "2000 textured triangles of medium size", func(r *rasterizer.Rasterizer) {
+			r.DrawTextured(pixelSpace(), mediumVert, mediumUVs, texture)
+		}},
+		{"perspective", "2000 perspective correct textured triangles", func(r *rasterizer.Rasterizer) {
+			r.DrawTextured(pixelSpace(), mediumVert, mediumUVs, texture, rasterizer.WithTextureMapping(rasterizer.MappingPerspective))
+		}},
+		{"overdraw", "32 full screen layers", func(r *rasterizer.Rasterizer) {
+			r.DrawFlat(pixelSpace(), overdrawVert, overdrawColors)
+		}},
+		{"instanced", "500 instances of a 12 triangle mesh", func(r *rasterizer.Rasterizer) {
+			r.DrawInstanced(cone, nil, cones)
+		}},
+	}
+}
+
+// NewTarget returns a back buffer for the scenes.
+func NewTarget() *image.Paletted {
+	return image.NewPaletted(image.Rect(0, 0, Width, Height), palette.Plan9)
+}
+
+// Run draws scene frames times and waits for every frame to finish. The
+// depth buffer and span buffer are cleared at the start of each frame.
+func Run(r *rasterizer.Rasterizer, scene Scene, frames int) Result {
+	before := r.Stats()
+	start := time.Now()
+
+	for i := 0; i < frames; i++ {
+		r.ClearDepth(1)
+		scene.Draw(r)
+		r.Sync()
+	}
+
+	return Result{Frames: frames, Duration: time.Since(start), Stats: r.Stats().Sub(before)}
+}
+
+// TrianglesPerSecond returns the rate primitives reached the raster stage.
+func (res *Result) TrianglesPerSecond() float64 {
+	return float64(res.Stats.Primitives) / res.Duration.Seconds()
+}
+
+// PixelsPerSecond returns the rate pixels were rasterized.
+func (res *Result) PixelsPerSecond() float64 {
+	return float64(res.Stats.Pixels) / res.Duration.Seconds()
+}
+
+// FrameTime returns the average wall clock time of a frame, or zero if no
+// frames were drawn.
+func (res *Result) FrameTime() time.Duration {
+	if res.Frames == 0 {
+		return 0
+	}
+	return res.Duration / time.Duration(res.Frames)
+}
+
+func pixelSpace() *mat4.T {
+	return &mat4.T{
+		{2 / float32(Width), 0, 0, 0},
+		{0, -2 / float32(Height), 0, 0},
+		{0, 0, 1, 0},
+		{-1, 1, 0, 1},
+	}
+}
+
+func randomTriangles(rnd *rand.Rand, n int, size float32) ([]vec3.T, []uint8) {
+	vert := make([]vec3.T, 0, n*3)
+	colors := make([]uint8, n)
+
+	for i := range colors {
+		x, y := rnd.Float32()*Width, rnd.Float32()*Height
+		for j := 0; j < 3; j++ {
+			vert = append(vert, vec3.T{x + (rnd.Float32()-0.5)*size, y + (rnd.Float32()-0.5)*size, rnd.Float32()})
+		}
+		colors[i] = uint8(rnd.Intn(256))
+	}
+	return vert, colors
+}
+
+func randomUVs(rnd *rand.Rand, n int) []vec2.T {
+	uvs := make([]vec2.T, n)
+	for i := range uvs {
+		uvs[i] = vec2.T{rnd.Float32(), rnd.Float32()}
+	}
+	return uvs
+}
+
+// randomInstances places instances of a mesh centered on the origin of
+// pixel space across the screen.
+func randomInstances(rnd *rand.Rand, n int) []rasterizer.Instance {
+	instances := make([]rasterizer.Instance, n)
+	for i := range instances {
+		inst := &instances[i]
+		inst.MVP = *pixelSpace()
+		inst.MVP[3][0] += rnd.Float32() * 2
+		inst.MVP[3][1] -= rnd.Float32() * 2
+		inst.ColorOffset = uint8(rnd.Intn(4) * 16)
+	}
+	return instances
+}
+
+// fanMesh returns a disc of n triangles around the origin.
+func fanMesh(n int, radius float32) *rasterizer.Mesh {
+	mesh := &rasterizer.Mesh{Topology: rasterizer.TriangleFan, Vertices: []vec3.T{{0, 0, 0}}}
+
+	for i := 0; i <= n; i++ {
+		a := float64(i) / float64(n) * 2 * math.Pi
+		mesh.Vertices = append(mesh.Vertices, vec3.T{radius * float32(math.Cos(a)), radius * float32(math.Sin(a)), 0})
+		if i < n {
+			mesh.Colors = append(mesh.Colors, uint8(16+i))
+		}
+	}
+	return mesh
+}
+
+func fullscreenLayers(n int) ([]vec3.T, []uint8) {
+	var (
+		vert   []vec3.T
+		colors []uint8
+	)
+
+	for i := 0; i < n; i++ {
+		vert = append(vert,
+			vec3.T{0, 0, 0}, vec3.T{Width, 0, 0}, vec3.T{Width, Height, 0},
+			vec3.T{0, 0, 0}, vec3.T{Width, Height, 0}, vec3.T{0, Height, 0})
+		colors = append(colors, uint8(i*8), uint8(i*8))
+	}
+	return vert, colors
+}
+
+func checkerboard(size, cell int) *image.Paletted {
+	img := image.NewPaletted(image.Rect(0, 0, size, size), palette.Plan9)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if (x/cell+y/cell)%2 == 1 {
+				img.SetColorIndex(x, y, 200)
+			} else {
+				img.SetColorIndex(x, y, 40)
+			}
+		}
+	}
+	return img
+}
@@ -0,0 +1,42 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+package bench
+
+import (
+	"testing"
+
+	"github.com/andreas-jonsson/drive/rasterizer"
+)
+
+func BenchmarkScanline(b *testing.B) {
+	benchmarkScenes(b)
+}
+
+func BenchmarkEdge(b *testing.B) {
+	benchmarkScenes(b, rasterizer.ConfigWithEdgeFunctions)
+}
+
+func benchmarkScenes(b *testing.B, configs ...rasterizer.Config) {
+	for _, scene := range Scenes() {
+		scene := scene
+		b.Run(scene.Name, func(b *testing.B) {
+			r, err := rasterizer.NewRasterizer(NewTarget(), configs...)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer r.Destroy()
+
+			b.ResetTimer()
+			res := Run(r, scene, b.N)
+			b.StopTimer()
+
+			b.ReportMetric(res.TrianglesPerSecond(), "tris/s")
+			b.ReportMetric(res.PixelsPerSecond(), "pixels/s")
+			b.ReportMetric(float64(res.Stats.GeometryTime.Nanoseconds())/float64(b.N), "geometry-ns/op")
+			b.ReportMetric(float64(res.Stats.RasterTime.Nanoseconds())/float64(b.N), "raster-ns/op")
+		})
+	}
+}
@@ -0,0 +1,161 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+package rasterizer
+
+import (
+	"image/color"
+	"sync"
+)
+
+// BlendMode selects a predefined blend table.
+type BlendMode int
+
+const (
+	BlendNone BlendMode = iota
+	BlendAverage
+	BlendAdditive
+	BlendMultiply
+	blendWeighted
+)
+
+// BlendTable holds the result of blending every pair of palette indices,
+// looked up as table[src][dst].
+type BlendTable struct {
+	table [256 * 256]uint8
+}
+
+type blendKey struct {
+	palette  string
+	mode     BlendMode
+	src, dst float32
+}
+
+// maxCachedBlendTables bounds the cache of CachedBlendTable. An arbitrary
+// table is evicted to make room for a new one.
+const maxCachedBlendTables = 16
+
+var blendCache = struct {
+	sync.Mutex
+	tables map[blendKey]*BlendTable
+}{tables: make(map[blendKey]*BlendTable)}
+
+// NewBlendTable builds the blend table of mode for pal.
+func NewBlendTable(pal color.Palette, mode BlendMode) *BlendTable {
+	switch mode {
+	case BlendAverage:
+		return NewWeightedBlendTable(pal, 0.5, 0.5)
+	case BlendAdditive:
+		return NewWeightedBlendTable(pal, 1, 1)
+	case BlendMultiply:
+		return newBlendTable(pal, func(s, d uint8) float32 {
+			return float32(s) * float32(d) / 255
+		})
+	}
+	return NewWeightedBlendTable(pal, 1, 0)
+}
+
+// NewWeightedBlendTable builds a blend table for pal where each channel is
+// src*srcWeight + dst*dstWeight.
+func NewWeightedBlendTable(pal color.Palette, srcWeight, dstWeight float32) *BlendTable {
+	return newBlendTable(pal, func(s, d uint8) float32 {
+		return float32(s)*srcWeight + float32(d)*dstWeight
+	})
+}
+
+func newBlendTable(pal color.Palette, fn func(s, d uint8) float32) *BlendTable {
+	var (
+		t   BlendTable
+		rgb = make([][3]uint8, len(pal))
+	)
+
+	for i, c := range pal {
+		r, g, b, _ := c.RGBA()
+		rgb[i] = [3]uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)}
+	}
+
+	channel := func(s, d uint8) uint8 {
+		v := fn(s, d)
+		if v > 255 {
+			return 255
+		}
+		return uint8(v + 0.5)
+	}
+
+	for s := range rgb {
+		for d := range rgb {
+			cs, cd := &rgb[s], &rgb[d]
+			t.table[s<<8|d] = nearestIndex(pal, channel(cs[0], cd[0]), channel(cs[1], cd[1]), channel(cs[2], cd[2]))
+		}
+	}
+	return &t
+}
+
+// Blend returns the palette index of src blended over dst.
+func (t *BlendTable) Blend(src, dst uint8) uint8 {
+	return t.table[int(src)<<8|int(dst)]
+}
+
+func paletteKey(pal color.Palette) string {
+	key := make([]byte, 0, len(pal)*3)
+	for _, c := range pal {
+		r, g, b, _ := c.RGBA()
+		key = append(key, uint8(r>>8), uint8(g>>8), uint8(b>>8))
+	}
+	return string(key)
+}
+
+func cachedBlendTable(key blendKey, build func() *BlendTable) *BlendTable {
+	blendCache.Lock()
+	defer blendCache.Unlock()
+
+	t, ok := blendCache.tables[key]
+	if !ok {
+		if len(blendCache.tables) >= maxCachedBlendTables {
+			for k := range blendCache.tables {
+				delete(blendCache.tables, k)
+				break
+			}
+		}
+
+		t = build()
+		blendCache.tables[key] = t
+	}
+	return t
+}
+
+// CachedBlendTable is like NewBlendTable but only builds the table the
+// first time it is requested for a palette with the same colors.
+func CachedBlendTable(pal color.Palette, mode BlendMode) *BlendTable {
+	return cachedBlendTable(blendKey{palette: paletteKey(pal), mode: mode}, func() *BlendTable {
+		return NewBlendTable(pal, mode)
+	})
+}
+
+// CachedWeightedBlendTable is the cached version of NewWeightedBlendTable.
+func CachedWeightedBlendTable(pal color.Palette, srcWeight, dstWeight float32) *BlendTable {
+	key := blendKey{palette: paletteKey(pal), mode: blendWeighted, src: srcWeight, dst: dstWeight}
+	return cachedBlendTable(key, func() *BlendTable {
+		return NewWeightedBlendTable(pal, srcWeight, dstWeight)
+	})
+}
+
+// WithBlend makes a draw call blend with the target using mode. The table
+// is built from the palette of the target by the geometry stage and kept
+// until the palette has been replaced a few times.
+func WithBlend(mode BlendMode) DrawOption {
+	return func(dc *drawCall) {
+		dc.blendMode = mode
+		dc.blend = nil
+	}
+}
+
+// WithBlendTable makes a draw call blend with the target through table.
+func WithBlendTable(table *BlendTable) DrawOption {
+	return func(dc *drawCall) {
+		dc.blendMode = BlendNone
+		dc.blend = table
+	}
+}
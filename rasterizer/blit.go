@@ -0,0 +1,236 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+package rasterizer
+
+import (
+	"image"
+	"math"
+)
+
+// Blit describes a 2D copy of a rectangle of a paletted image onto
+// another, with optional scaling, flipping, rotation, color key, shading and
+// blending.
+type Blit struct {
+	Src *image.Paletted
+
+	// SrcRect is the part of Src to copy. The zero rectangle copies all of
+	// Src. Parts of SrcRect outside of Src are transparent.
+	SrcRect image.Rectangle
+
+	// DstRect is where SrcRect lands in the target before rotation. The
+	// source is scaled to fit.
+	DstRect image.Rectangle
+
+	FlipH, FlipV bool
+
+	// Angle rotates the blit clockwise, in radians, around Pivot. Pivot is
+	// relative to DstRect.Min.
+	Angle float32
+	Pivot image.Point
+
+	// ColorKey enables skipping of source pixels with the palette index
+	// Transparent.
+	ColorKey    bool
+	Transparent uint8
+
+	// Shade, if set, lights the source pixels with Light. Blend, if set,
+	// blends them with the target.
+	Shade *ShadeTable
+	Light float32
+	Blend *BlendTable
+}
+
+// Blitter draws blits directly to a target, for use outside of the
+// rasterizer, e.g. in GameState.Render.
+type Blitter struct {
+	target *image.Paletted
+	clips  clipStack
+}
+
+// NewBlitter creates a blitter drawing to target, clipped to its bounds.
+func NewBlitter(target *image.Paletted) *Blitter {
+	return &Blitter{target: target, clips: clipStack{bounds: target.Bounds()}}
+}
+
+// PushClip restricts drawing to the intersection of rect and the current
+// clip rectangle.
+func (b *Blitter) PushClip(rect image.Rectangle) {
+	b.clips.push(rect)
+}
+
+// PopClip restores the clip rectangle active before the last PushClip.
+func (b *Blitter) PopClip() {
+	b.clips.pop()
+}
+
+// Clip returns the current clip rectangle.
+func (b *Blitter) Clip() image.Rectangle {
+	return b.clips.top()
+}
+
+// Draw performs blit immediately.
+func (b *Blitter) Draw(blit *Blit) {
+	drawBlit(b.target, b.clips.top(), blit)
+}
+
+// DrawBlit queues blit. It is clipped to the current clip rectangle of the
+// rasterizer and ignores the depth buffer. WithShadeTable, WithLight,
+// WithBlend and WithBlendTable apply if the blit has no shade or blend table
+// of its own.
+func (r *Rasterizer) DrawBlit(blit Blit, opts ...DrawOption) uint64 {
+	return r.submit(&drawCall{prim: primBlit, blit: &blit}, opts)
+}
+
+type clipStack struct {
+	bounds image.Rectangle
+	rects  []image.Rectangle
+}
+
+func (s *clipStack) push(rect image.Rectangle) {
+	s.rects = append(s.rects, rect.Intersect(s.top()))
+}
+
+func (s *clipStack) pop() {
+	if len(s.rects) > 0 {
+		s.rects = s.rects[:len(s.rects)-1]
+	}
+}
+
+func (s *clipStack) top() image.Rectangle {
+	if len(s.rects) == 0 {
+		return s.bounds
+	}
+	return s.rects[len(s.rects)-1]
+}
+
+// blitBounds returns the bounding box of the rotated destination rectangle
+// and the sine and cosine of the rotation.
+func blitBounds(blit *Blit) (image.Rectangle, float32, float32) {
+	if blit.Angle == 0 {
+		return blit.DstRect, 0, 1
+	}
+
+	sin, cos := math.Sincos(float64(blit.Angle))
+	s, c := float32(sin), float32(cos)
+
+	pivot := blit.DstRect.Min.Add(blit.Pivot)
+	px, py := float32(pivot.X), float32(pivot.Y)
+
+	minX, minY := float32(math.MaxFloat32), float32(math.MaxFloat32)
+	maxX, maxY := -minX, -minY
+
+	r := blit.DstRect
+	for _, p := range [...]image.Point{r.Min, {r.Max.X, r.Min.Y}, {r.Min.X, r.Max.Y}, r.Max} {
+		dx, dy := float32(p.X)-px, float32(p.Y)-py
+		x, y := px+dx*c-dy*s, py+dx*s+dy*c
+
+		minX, maxX = float32(math.Min(float64(minX), float64(x))), float32(math.Max(float64(maxX), float64(x)))
+		minY, maxY = float32(math.Min(float64(minY), float64(y))), float32(math.Max(float64(maxY), float64(y)))
+	}
+
+	return image.Rect(floor(minX), floor(minY), floor(maxX)+1, floor(maxY)+1), s, c
+}
+
+// drawBlit maps every target pixel inside clip back to the source, so
+// splitting clip into tiles gives the same result as a single call.
+func drawBlit(dst *image.Paletted, clip image.Rectangle, blit *Blit) (pixels int) {
+	src := blit.SrcRect
+	if src.Empty() {
+		src = blit.Src.Rect
+	}
+
+	dstRect := blit.DstRect
+	if dstRect.Empty() || src.Intersect(blit.Src.Rect).Empty() {
+		return 0
+	}
+
+	bounds, sin, cos := blitBounds(blit)
+	bounds = bounds.Intersect(clip).Intersect(dst.Rect)
+
+	pivot := dstRect.Min.Add(blit.Pivot)
+	px, py := float32(pivot.X), float32(pivot.Y)
+
+	scaleX := float32(src.Dx()) / float32(dstRect.Dx())
+	scaleY := float32(src.Dy()) / float32(dstRect.Dy())
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			// Rotate the pixel center back into the unrotated rectangle.
+			dx, dy := float32(x)+0.5-px, float32(y)+0.5-py
+			qx, qy := px+dx*cos+dy*sin, py-dx*sin+dy*cos
+
+			sx := floor((qx - float32(dstRect.Min.X)) * scaleX)
+			sy := floor((qy - float32(dstRect.Min.Y)) * scaleY)
+
+			if sx < 0 || sy < 0 || sx >= src.Dx() || sy >= src.Dy() {
+				continue
+			}
+
+			if blit.FlipH {
+				sx = src.Dx() - 1 - sx
+			}
+			if blit.FlipV {
+				sy = src.Dy() - 1 - sy
+			}
+
+			p := image.Pt(src.Min.X+sx, src.Min.Y+sy)
+			if !p.In(blit.Src.Rect) {
+				continue
+			}
+
+			index := blit.Src.Pix[blit.Src.PixOffset(p.X, p.Y)]
+			if blit.ColorKey && index == blit.Transparent {
+				continue
+			}
+
+			if blit.Shade != nil {
+				index = blit.Shade.Shade(index, blit.Light)
+			}
+
+			i := dst.PixOffset(x, y)
+			if blit.Blend != nil {
+				index = blit.Blend.Blend(index, dst.Pix[i])
+			}
+			dst.Pix[i] = index
+			pixels++
+		}
+	}
+	return
+}
+
+func (r *Rasterizer) processBlit(dc *drawCall) {
+	blit := dc.blit
+	if blit.Shade == nil && dc.shadeTable != nil {
+		blit.Shade, blit.Light = dc.shadeTable, dc.light
+	}
+	if blit.Blend == nil {
+		blit.Blend = dc.blend
+	}
+
+	bounds, _, _ := blitBounds(blit)
+
+	tri := triangle{cmd: cmdDraw, id: dc.id, dc: dc, prim: primBlit}
+	tri.v[0].x, tri.v[0].y = float32(bounds.Min.X), float32(bounds.Min.Y)
+	tri.v[1].x, tri.v[1].y = float32(bounds.Max.X-1), float32(bounds.Max.Y-1)
+	tri.v[2] = tri.v[1]
+
+	r.emit(tri)
+}
+
+// PushClip restricts all following draw calls to the intersection of rect
+// and the current clip rectangle.
+func (r *Rasterizer) PushClip(rect image.Rectangle) {
+	r.submitMutex.Lock()
+	r.clips.push(rect)
+	r.submitMutex.Unlock()
+}
+
+// PopClip restores the clip rectangle active before the last PushClip.
+func (r *Rasterizer) PopClip() {
+	r.submitMutex.Lock()
+	r.clips.pop()
+	r.submitMutex.Unlock()
+}
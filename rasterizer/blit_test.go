@@ -0,0 +1,54 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+package rasterizer_test
+
+import (
+	"image"
+	"image/color/palette"
+	"testing"
+
+	"github.com/andreas-jonsson/drive/rasterizer"
+)
+
+// TestBlitSrcRect checks that the parts of a source rectangle outside of
+// the source image are skipped, without changing the scale of the rest.
+func TestBlitSrcRect(t *testing.T) {
+	src := image.NewPaletted(image.Rect(0, 0, 8, 8), palette.Plan9)
+	for i := range src.Pix {
+		src.Pix[i] = 255
+	}
+
+	// The top left 4x4 pixels of the source rectangle are inside the source
+	// and land at 0, 0 to 8, 8.
+	blit := rasterizer.Blit{
+		Src:     src,
+		SrcRect: image.Rect(4, 4, 16, 16),
+		DstRect: image.Rect(0, 0, 24, 24),
+	}
+
+	check := func(name string, img *image.Paletted) {
+		for y := 0; y < goldenHeight; y++ {
+			for x := 0; x < goldenWidth; x++ {
+				want := uint8(0)
+				if x < 8 && y < 8 {
+					want = 255
+				}
+				if got := img.ColorIndexAt(x, y); got != want {
+					t.Fatalf("%s: pixel %d,%d is %d, want %d", name, x, y, got, want)
+				}
+			}
+		}
+	}
+
+	img := image.NewPaletted(image.Rect(0, 0, goldenWidth, goldenHeight), palette.Plan9)
+	rasterizer.NewBlitter(img).Draw(&blit)
+	check("blitter", img)
+
+	check("rasterizer", render(t, nil, func(r *rasterizer.Rasterizer) {
+		r.DrawBlit(blit)
+		r.DrawBlit(rasterizer.Blit{Src: src, SrcRect: image.Rect(8, 8, 16, 16), DstRect: image.Rect(0, 0, 8, 8)})
+	}))
+}
@@ -0,0 +1,526 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+package rasterizer
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+
+	"github.com/ungerik/go3d/mat4"
+	"github.com/ungerik/go3d/vec2"
+	"github.com/ungerik/go3d/vec3"
+)
+
+const captureVersion = 1
+
+// Capture is a recording of the draw calls submitted to a rasterizer
+// between BeginCapture and EndCapture. Images, render targets and tables
+// are stored with the contents they had when first referenced, so a capture
+// can be written to a file and replayed elsewhere.
+//
+// Custom pixel shaders can not be recorded, draw calls using them are
+// replayed with the default shader. Depth buffers are not recorded either
+// and start out cleared to +Inf.
+type Capture struct {
+	file captureFile
+
+	images      map[*image.Paletted]int
+	shadeTables map[*ShadeTable]int
+	blendTables map[*BlendTable]int
+}
+
+type captureFile struct {
+	Version int
+
+	EdgeFunctions bool
+	SpanBuffer    bool
+	BackBuffer    int
+
+	Images      []capturedImage
+	ShadeTables []capturedShadeTable
+	BlendTables [][]uint8
+	DrawCalls   []capturedDrawCall
+}
+
+type capturedImage struct {
+	Rect    image.Rectangle
+	Stride  int
+	Pix     []uint8
+	Palette []color.RGBA
+}
+
+type capturedShadeTable struct {
+	Levels    int
+	Brightest float32
+	Table     []uint8
+}
+
+type capturedBlit struct {
+	Src              int
+	SrcRect, DstRect image.Rectangle
+	FlipH, FlipV     bool
+	Angle            float32
+	Pivot            image.Point
+	ColorKey         bool
+	Transparent      uint8
+	Shade            int
+	Light            float32
+	Blend            int
+}
+
+type capturedFog struct {
+	Mode       FogMode
+	Start, End float32
+	Density    float32
+	Color      color.RGBA
+}
+
+// Image and table references are indices, -1 means none.
+type capturedDrawCall struct {
+	Cmd      command
+	Prim     primitive
+	FillMode FillMode
+	Vert     []vec3.T
+	UVs      []vec2.T
+	Colors   []uint8
+	Indices  []uint16
+	Topology Topology
+	Texture  []int
+	MVP      mat4.T
+	Mapping  TextureMapping
+
+	Instances []Instance
+
+	Normals     []vec3.T
+	Model       mat4.T
+	Ambient     float32
+	SceneLights []Light
+
+	CustomShader bool
+	Sampler      Sampler
+	Ramp         []uint8
+	Dither       bool
+
+	ShadeTable  int
+	Light       float32
+	Lights      []float32
+	Intensities []float32
+
+	BlendMode BlendMode
+	Blend     int
+
+	Fog       capturedFog
+	Blit      *capturedBlit
+	DebugMode DebugMode
+
+	Target      int
+	TargetDepth bool
+	Viewport    image.Rectangle
+	Clip        image.Rectangle
+	CullMode    CullMode
+	FrontFace   Winding
+
+	DepthFunc  DepthFunc
+	DepthWrite bool
+	DepthClear float32
+}
+
+// BeginCapture waits for everything submitted so far to be rasterized and
+// starts recording draw calls.
+func (r *Rasterizer) BeginCapture() {
+	r.Sync()
+
+	c := &Capture{
+		images:      make(map[*image.Paletted]int),
+		shadeTables: make(map[*ShadeTable]int),
+		blendTables: make(map[*BlendTable]int),
+	}
+	c.file.Version = captureVersion
+	c.file.EdgeFunctions = r.edgeFunctions
+	c.file.SpanBuffer = r.spanBuffer
+
+	r.submitMutex.Lock()
+	c.file.BackBuffer = c.image(r.backBuffer.Image)
+	r.capture = c
+	r.submitMutex.Unlock()
+}
+
+// EndCapture stops recording and returns the capture. It returns nil if
+// BeginCapture was not called.
+func (r *Rasterizer) EndCapture() *Capture {
+	r.submitMutex.Lock()
+	c := r.capture
+	r.capture = nil
+	r.submitMutex.Unlock()
+	return c
+}
+
+// DecodeCapture decodes a capture written by Capture.Encode.
+func DecodeCapture(rd io.Reader) (*Capture, error) {
+	c := new(Capture)
+	if err := gob.NewDecoder(rd).Decode(&c.file); err != nil {
+		return nil, err
+	}
+	if c.file.Version != captureVersion {
+		return nil, fmt.Errorf("unsupported capture version: %d", c.file.Version)
+	}
+	return c, nil
+}
+
+// Encode writes the capture to w.
+func (c *Capture) Encode(w io.Writer) error {
+	return gob.NewEncoder(w).Encode(&c.file)
+}
+
+// Len returns the number of recorded draw calls.
+func (c *Capture) Len() int {
+	return len(c.file.DrawCalls)
+}
+
+// Describe returns a short description of draw call i.
+func (c *Capture) Describe(i int) string {
+	dc := &c.file.DrawCalls[i]
+
+	if dc.Cmd == cmdClearDepth {
+		return fmt.Sprintf("clear depth %v, target %d", dc.DepthClear, dc.Target)
+	}
+
+	var kind string
+	switch dc.Prim {
+	case primLine:
+		kind = fmt.Sprintf("%d lines", len(dc.Vert)/2)
+	case primPoint:
+		kind = fmt.Sprintf("%d points", len(dc.Vert))
+	case primBlit:
+		kind = fmt.Sprintf("blit of image %d", dc.Blit.Src)
+	default:
+		n := len(dc.Vert)
+		if dc.Indices != nil {
+			n = len(dc.Indices)
+		}
+		kind = fmt.Sprintf("%d vertices", n)
+		if dc.Topology != TriangleList {
+			kind += fmt.Sprintf(" in topology %d", dc.Topology)
+		}
+		if dc.Instances != nil {
+			kind += fmt.Sprintf(" times %d instances", len(dc.Instances))
+		}
+		if dc.Texture != nil {
+			kind += fmt.Sprintf(", texture %d", dc.Texture[0])
+		}
+	}
+
+	s := fmt.Sprintf("draw %s, target %d, viewport %v", kind, dc.Target, dc.Viewport)
+	if dc.CustomShader {
+		s += ", custom shader"
+	}
+	return s
+}
+
+// Replay rasterizes the capture on a new rasterizer, one draw call at a
+// time. After each draw call step is called with its index and the back
+// buffer. Replay stops at the first error returned by step.
+func (c *Capture) Replay(step func(i int, backBuffer *image.Paletted) error) error {
+	f := &c.file
+	if f.BackBuffer < 0 || f.BackBuffer >= len(f.Images) {
+		return errors.New("capture has no back buffer")
+	}
+
+	images := make([]*image.Paletted, len(f.Images))
+	for i := range f.Images {
+		images[i] = f.Images[i].restore()
+	}
+
+	shadeTables := make([]*ShadeTable, len(f.ShadeTables))
+	for i, t := range f.ShadeTables {
+		shadeTables[i] = &ShadeTable{levels: t.Levels, brightest: t.Brightest, table: t.Table}
+	}
+
+	blendTables := make([]*BlendTable, len(f.BlendTables))
+	for i, t := range f.BlendTables {
+		blendTables[i] = new(BlendTable)
+		copy(blendTables[i].table[:], t)
+	}
+
+	var configs []Config
+	if f.EdgeFunctions {
+		configs = append(configs, ConfigWithEdgeFunctions)
+	}
+	if f.SpanBuffer {
+		configs = append(configs, ConfigWithSpanBuffer)
+	}
+	for i := range f.DrawCalls {
+		if dc := &f.DrawCalls[i]; dc.Target == f.BackBuffer && dc.TargetDepth {
+			configs = append(configs, ConfigWithDepthBuffer)
+			break
+		}
+	}
+
+	backBuffer := images[f.BackBuffer]
+	r, err := NewRasterizer(backBuffer, configs...)
+	if err != nil {
+		return err
+	}
+	defer r.Destroy()
+
+	targets := map[int]*RenderTarget{f.BackBuffer: r.backBuffer}
+
+	for i := range f.DrawCalls {
+		cd := &f.DrawCalls[i]
+
+		rt, ok := targets[cd.Target]
+		if !ok {
+			rt = NewRenderTarget(images[cd.Target], cd.TargetDepth)
+			targets[cd.Target] = rt
+		}
+
+		r.SetRenderTarget(rt)
+		r.SetViewport(cd.Viewport)
+		r.PushClip(cd.Clip)
+		r.SetFrontFace(cd.FrontFace)
+		r.SetDebugMode(cd.DebugMode)
+		r.SetLights(cd.Ambient, cd.SceneLights...)
+		r.SetFog(Fog{Mode: cd.Fog.Mode, Start: cd.Fog.Start, End: cd.Fog.End, Density: cd.Fog.Density, Color: cd.Fog.Color})
+
+		dc := &drawCall{
+			cmd:      cd.Cmd,
+			prim:     cd.Prim,
+			vert:     cd.Vert,
+			uvs:      cd.UVs,
+			colors:   cd.Colors,
+			indices:  cd.Indices,
+			topology: cd.Topology,
+			mvp:      cd.MVP,
+
+			instances: cd.Instances,
+			normals:   cd.Normals,
+		}
+
+		if cd.Texture != nil {
+			levels := make([]*image.Paletted, len(cd.Texture))
+			for j, id := range cd.Texture {
+				levels[j] = images[id]
+			}
+			dc.texture = NewTextureLevels(levels...)
+		}
+
+		if b := cd.Blit; b != nil {
+			dc.blit = &Blit{
+				Src:         images[b.Src],
+				SrcRect:     b.SrcRect,
+				DstRect:     b.DstRect,
+				FlipH:       b.FlipH,
+				FlipV:       b.FlipV,
+				Angle:       b.Angle,
+				Pivot:       b.Pivot,
+				ColorKey:    b.ColorKey,
+				Transparent: b.Transparent,
+				Light:       b.Light,
+			}
+			if b.Shade >= 0 {
+				dc.blit.Shade = shadeTables[b.Shade]
+			}
+			if b.Blend >= 0 {
+				dc.blit.Blend = blendTables[b.Blend]
+			}
+		}
+
+		state := func(dc *drawCall) {
+			dc.fillMode = cd.FillMode
+			dc.mapping = cd.Mapping
+			dc.model = cd.Model
+			dc.uniforms.Sampler = cd.Sampler
+			dc.uniforms.Ramp = cd.Ramp
+			dc.uniforms.Dither = cd.Dither
+			dc.light = cd.Light
+			dc.lights = cd.Lights
+			dc.intensities = cd.Intensities
+			dc.blendMode = cd.BlendMode
+			dc.cullMode = cd.CullMode
+			dc.depthFunc = cd.DepthFunc
+			dc.depthWrite = cd.DepthWrite
+			dc.depthClear = cd.DepthClear
+
+			if cd.ShadeTable >= 0 {
+				dc.shadeTable = shadeTables[cd.ShadeTable]
+			}
+			if cd.Blend >= 0 {
+				dc.blend = blendTables[cd.Blend]
+			}
+		}
+
+		r.Wait(r.submit(dc, []DrawOption{state}))
+		if err := step(i, backBuffer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// record appends dc to the capture. It is called with the submit mutex
+// held, after all state of dc has been resolved.
+func (c *Capture) record(dc *drawCall) {
+	if dc.cmd == cmdFence {
+		return
+	}
+
+	cd := capturedDrawCall{
+		Cmd:      dc.cmd,
+		Prim:     dc.prim,
+		FillMode: dc.fillMode,
+		Vert:     append([]vec3.T(nil), dc.vert...),
+		UVs:      append([]vec2.T(nil), dc.uvs...),
+		Colors:   append([]uint8(nil), dc.colors...),
+		Indices:  append([]uint16(nil), dc.indices...),
+		Topology: dc.topology,
+		MVP:      dc.mvp,
+		Mapping:  dc.mapping,
+
+		Instances: append([]Instance(nil), dc.instances...),
+
+		Normals: append([]vec3.T(nil), dc.normals...),
+		Model:   dc.model,
+		Ambient: dc.lighting.ambient,
+
+		Sampler: dc.uniforms.Sampler,
+		Ramp:    append([]uint8(nil), dc.uniforms.Ramp...),
+		Dither:  dc.uniforms.Dither,
+
+		ShadeTable:  c.shadeTable(dc.shadeTable),
+		Light:       dc.light,
+		Lights:      append([]float32(nil), dc.lights...),
+		Intensities: append([]float32(nil), dc.intensities...),
+
+		BlendMode: dc.blendMode,
+		Blend:     -1,
+
+		DebugMode: dc.debugMode,
+
+		Target:      c.image(dc.target.Image),
+		TargetDepth: dc.target.depth != nil,
+		Viewport:    dc.viewport,
+		Clip:        dc.clip,
+		CullMode:    dc.cullMode,
+		FrontFace:   dc.frontFace,
+
+		DepthFunc:  dc.depthFunc,
+		DepthWrite: dc.depthWrite,
+		DepthClear: dc.depthClear,
+	}
+
+	switch dc.shader.(type) {
+	case flatShader, texturedShader, gouraudShader:
+	default:
+		cd.CustomShader = true
+	}
+
+	for _, l := range dc.lighting.lights {
+		cd.SceneLights = append(cd.SceneLights, l.Light)
+	}
+
+	// Tables resolved from a blend mode are rebuilt on replay.
+	if dc.blendMode == BlendNone {
+		cd.Blend = c.blendTable(dc.blend)
+	}
+
+	if dc.texture != nil {
+		for _, level := range dc.texture.levels {
+			cd.Texture = append(cd.Texture, c.image(level))
+		}
+	}
+
+	if fog := dc.fog; fog.Mode != FogNone {
+		cd.Fog = capturedFog{Mode: fog.Mode, Start: fog.Start, End: fog.End, Density: fog.Density}
+		cd.Fog.Color = color.RGBAModel.Convert(fog.Color).(color.RGBA)
+	}
+
+	if b := dc.blit; b != nil {
+		cd.Blit = &capturedBlit{
+			Src:         c.image(b.Src),
+			SrcRect:     b.SrcRect,
+			DstRect:     b.DstRect,
+			FlipH:       b.FlipH,
+			FlipV:       b.FlipV,
+			Angle:       b.Angle,
+			Pivot:       b.Pivot,
+			ColorKey:    b.ColorKey,
+			Transparent: b.Transparent,
+			Shade:       c.shadeTable(b.Shade),
+			Light:       b.Light,
+			Blend:       c.blendTable(b.Blend),
+		}
+	}
+
+	c.file.DrawCalls = append(c.file.DrawCalls, cd)
+}
+
+// image returns the index of img in the capture, taking a snapshot of it
+// the first time it is referenced.
+func (c *Capture) image(img *image.Paletted) int {
+	if id, ok := c.images[img]; ok {
+		return id
+	}
+
+	ci := capturedImage{
+		Rect:   img.Rect,
+		Stride: img.Stride,
+		Pix:    append([]uint8(nil), img.Pix...),
+	}
+	for _, col := range img.Palette {
+		ci.Palette = append(ci.Palette, color.RGBAModel.Convert(col).(color.RGBA))
+	}
+
+	id := len(c.file.Images)
+	c.file.Images = append(c.file.Images, ci)
+	c.images[img] = id
+	return id
+}
+
+func (c *Capture) shadeTable(t *ShadeTable) int {
+	if t == nil {
+		return -1
+	}
+	if id, ok := c.shadeTables[t]; ok {
+		return id
+	}
+
+	id := len(c.file.ShadeTables)
+	c.file.ShadeTables = append(c.file.ShadeTables, capturedShadeTable{t.levels, t.brightest, t.table})
+	c.shadeTables[t] = id
+	return id
+}
+
+func (c *Capture) blendTable(t *BlendTable) int {
+	if t == nil {
+		return -1
+	}
+	if id, ok := c.blendTables[t]; ok {
+		return id
+	}
+
+	id := len(c.file.BlendTables)
+	c.file.BlendTables = append(c.file.BlendTables, append([]uint8(nil), t.table[:]...))
+	c.blendTables[t] = id
+	return id
+}
+
+func (ci *capturedImage) restore() *image.Paletted {
+	pal := make(color.Palette, len(ci.Palette))
+	for i, col := range ci.Palette {
+		pal[i] = col
+	}
+
+	return &image.Paletted{
+		Pix:     append([]uint8(nil), ci.Pix...),
+		Stride:  ci.Stride,
+		Rect:    ci.Rect,
+		Palette: pal,
+	}
+}
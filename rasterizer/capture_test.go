@@ -0,0 +1,76 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+package rasterizer_test
+
+import (
+	"bytes"
+	"image"
+	"image/color/palette"
+	"testing"
+
+	"github.com/andreas-jonsson/drive/rasterizer"
+	"github.com/ungerik/go3d/mat4"
+	"github.com/ungerik/go3d/vec2"
+	"github.com/ungerik/go3d/vec3"
+)
+
+// TestCaptureReplay checks that replaying an encoded capture reproduces the
+// frame it was recorded from, including a pass through a render target.
+func TestCaptureReplay(t *testing.T) {
+	img := image.NewPaletted(image.Rect(0, 0, goldenWidth, goldenHeight), palette.Plan9)
+
+	r, err := rasterizer.NewRasterizer(img, rasterizer.ConfigWithDepthBuffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Destroy()
+
+	offscreen := rasterizer.NewRenderTarget(image.NewPaletted(image.Rect(0, 0, 16, 16), palette.Plan9), false)
+	quad := []vec3.T{{-1, -1, 0}, {1, -1, 0}, {1, 1, 0}, {-1, -1, 0}, {1, 1, 0}, {-1, 1, 0}}
+	uvs := []vec2.T{{0, 1}, {1, 1}, {1, 0}, {0, 1}, {1, 0}, {0, 0}}
+
+	r.BeginCapture()
+	r.ClearDepth(1)
+	r.SetRenderTarget(offscreen)
+	r.DrawFlat(&mat4.Ident, quad, []uint8{40, 200})
+	r.SetRenderTarget(nil)
+	sceneTextured(r)
+	r.DrawTextured(&mat4.Ident, quad[:3], uvs[:3], offscreen.Image, rasterizer.WithBlend(rasterizer.BlendAverage))
+	r.Sync()
+
+	capture := r.EndCapture()
+
+	var buf bytes.Buffer
+	if err := capture.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	capture, err = rasterizer.DecodeCapture(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := 0
+	err = capture.Replay(func(i int, backBuffer *image.Paletted) error {
+		if steps++; steps < capture.Len() {
+			return nil
+		}
+
+		for j := range img.Pix {
+			if img.Pix[j] != backBuffer.Pix[j] {
+				t.Errorf("pixel %d,%d is %d, want %d", j%goldenWidth, j/goldenWidth, backBuffer.Pix[j], img.Pix[j])
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if steps != 5 {
+		t.Errorf("replayed %d draw calls, want 5", steps)
+	}
+}
@@ -0,0 +1,131 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+package rasterizer
+
+import (
+	"image"
+
+	"github.com/ungerik/go3d/vec4"
+)
+
+// A triangle clipped against six planes gains at most one vertex per plane.
+const maxClipVertices = 3 + 6
+
+// Frustum planes in clip space. A vertex p is inside a plane if the dot
+// product of the plane and p is non-negative, e.g. x >= -w for the first one.
+var clipPlanes = [...]vec4.T{
+	{1, 0, 0, 1},
+	{-1, 0, 0, 1},
+	{0, 1, 0, 1},
+	{0, -1, 0, 1},
+	{0, 0, 1, 1},
+	{0, 0, -1, 1},
+}
+
+type clipVertex struct {
+	pos vec4.T
+	varyings
+}
+
+type clipper struct {
+	buffers [2][maxClipVertices]clipVertex
+}
+
+func planeDistance(plane, p *vec4.T) float32 {
+	return plane[0]*p[0] + plane[1]*p[1] + plane[2]*p[2] + plane[3]*p[3]
+}
+
+func outcode(p *vec4.T) (code uint) {
+	for i := range clipPlanes {
+		if planeDistance(&clipPlanes[i], p) < 0 {
+			code |= 1 << uint(i)
+		}
+	}
+	return
+}
+
+// clip clips the triangle a, b, c against the view frustum using
+// Sutherland-Hodgman and returns the resulting convex polygon. Varyings of
+// generated vertices are interpolated along the clipped edge. The returned
+// slice is only valid until the next call.
+func (c *clipper) clip(a, b, v *clipVertex) []clipVertex {
+	ca, cb, cc := outcode(&a.pos), outcode(&b.pos), outcode(&v.pos)
+
+	in := c.buffers[0][:0]
+	in = append(in, *a, *b, *v)
+
+	if ca|cb|cc == 0 {
+		return in
+	}
+	if ca&cb&cc != 0 {
+		return nil
+	}
+
+	out := c.buffers[1][:0]
+	codes := ca | cb | cc
+
+	for i := range clipPlanes {
+		if codes&(1<<uint(i)) == 0 {
+			continue
+		}
+
+		plane := &clipPlanes[i]
+		out = out[:0]
+
+		for j := range in {
+			p := &in[j]
+			q := &in[(j+1)%len(in)]
+
+			dp := planeDistance(plane, &p.pos)
+			dq := planeDistance(plane, &q.pos)
+
+			if dp >= 0 {
+				out = append(out, *p)
+			}
+
+			if (dp >= 0) != (dq >= 0) {
+				t := dp / (dp - dq)
+
+				var n clipVertex
+				for k := range n.pos {
+					n.pos[k] = p.pos[k] + (q.pos[k]-p.pos[k])*t
+				}
+				lerpVaryings(&n.varyings, &p.varyings, &q.varyings, t)
+				out = append(out, n)
+			}
+		}
+
+		if len(out) < 3 {
+			return nil
+		}
+		in, out = out, in
+	}
+
+	return in
+}
+
+// project performs the perspective divide on a clipped vertex and maps it
+// to the viewport. Depth is mapped to the range 0 to 1.
+func project(cv *clipVertex, viewport image.Rectangle, v *vertex) {
+	rw := 1 / cv.pos[3]
+
+	v.x = float32(viewport.Min.X) + (cv.pos[0]*rw+1)*0.5*float32(viewport.Dx())
+	v.y = float32(viewport.Min.Y) + (1-cv.pos[1]*rw)*0.5*float32(viewport.Dy())
+
+	v.varyings = cv.varyings
+	v.varyings[varyingZ] = cv.pos[2]*rw*0.5 + 0.5
+	v.varyings[varyingW] = rw
+}
+
+// SetViewport sets the rectangle of the render target that normalized
+// device coordinates are mapped to. Rasterization is clipped to the
+// viewport. It applies to draw calls submitted after the call and defaults
+// to the bounds of the back buffer.
+func (r *Rasterizer) SetViewport(viewport image.Rectangle) {
+	r.submitMutex.Lock()
+	r.viewport = viewport
+	r.submitMutex.Unlock()
+}
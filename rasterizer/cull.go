@@ -0,0 +1,59 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+package rasterizer
+
+// CullMode selects which triangles of a draw call are discarded based on
+// their facing.
+type CullMode int
+
+const (
+	CullNone CullMode = iota
+	CullBack
+	CullFront
+)
+
+// Winding is the vertex order, as seen in normalized device coordinates,
+// that makes a triangle front facing.
+type Winding int
+
+const (
+	WindingCCW Winding = iota
+	WindingCW
+)
+
+// WithCullMode sets the cull mode of a draw call. Culling is disabled by
+// default.
+func WithCullMode(mode CullMode) DrawOption {
+	return func(dc *drawCall) {
+		dc.cullMode = mode
+	}
+}
+
+// SetFrontFace sets the winding of front facing triangles for draw calls
+// submitted after the call. The default is WindingCCW.
+func (r *Rasterizer) SetFrontFace(winding Winding) {
+	r.submitMutex.Lock()
+	r.frontFace = winding
+	r.submitMutex.Unlock()
+}
+
+// cull reports whether the projected triangle should be discarded. Screen
+// space y grows downwards, so counter-clockwise triangles in normalized
+// device coordinates have a negative signed area on screen.
+func cull(tri *triangle, dc *drawCall) bool {
+	a, b, c := &tri.v[0], &tri.v[1], &tri.v[2]
+	area := (b.x-a.x)*(c.y-a.y) - (c.x-a.x)*(b.y-a.y)
+
+	front := area < 0
+	if dc.frontFace == WindingCW {
+		front = area > 0
+	}
+
+	if dc.cullMode == CullBack {
+		return !front && area != 0
+	}
+	return front
+}
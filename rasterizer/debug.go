@@ -0,0 +1,81 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+package rasterizer
+
+import "image/color"
+
+// Writes to a pixel in excess of this saturate the overdraw heat map.
+const heatLevels = 16
+
+// DebugMode replaces the output of draw calls with debug information.
+type DebugMode int
+
+const (
+	DebugNone DebugMode = iota
+
+	// DebugOverdraw writes a heat map of how many times each pixel has
+	// been written since the last ClearDepth, from blue for a single write
+	// to white for 15 or more. Blits are not counted.
+	DebugOverdraw
+)
+
+// Colors of the heat map, evenly spread over the levels.
+var heatColors = [...]color.RGBA{
+	{0, 0, 0, 255},
+	{0, 0, 255, 255},
+	{0, 255, 255, 255},
+	{0, 255, 0, 255},
+	{255, 255, 0, 255},
+	{255, 0, 0, 255},
+	{255, 255, 255, 255},
+}
+
+type heatTable [heatLevels]uint8
+
+// heatMap counts the writes to every pixel of a render target.
+type heatMap []uint8
+
+func newHeatTable(pal color.Palette) *heatTable {
+	var t heatTable
+
+	for level := range t {
+		pos := float32(level) / (heatLevels - 1) * float32(len(heatColors)-1)
+		i := int(pos)
+		if i == len(heatColors)-1 {
+			i--
+		}
+
+		a, b := heatColors[i], heatColors[i+1]
+		f := pos - float32(i)
+		lerp := func(a, b uint8) uint8 {
+			return uint8(float32(a)*(1-f) + float32(b)*f + 0.5)
+		}
+
+		t[level] = nearestIndex(pal, lerp(a.R, b.R), lerp(a.G, b.G), lerp(a.B, b.B))
+	}
+	return &t
+}
+
+// count increments the write count of pixel i and returns its heat color.
+func (h heatMap) count(i int, table *heatTable) uint8 {
+	if h[i] < heatLevels-1 {
+		h[i]++
+	}
+	return table[h[i]]
+}
+
+func (h heatMap) clear() {
+	for i := range h {
+		h[i] = 0
+	}
+}
+
+// SetDebugMode sets the debug mode of draw calls submitted after the call.
+func (r *Rasterizer) SetDebugMode(mode DebugMode) {
+	r.submitMutex.Lock()
+	r.debugMode = mode
+	r.submitMutex.Unlock()
+}
@@ -0,0 +1,80 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+package rasterizer
+
+import "math"
+
+// DepthFunc selects how an incoming fragment's depth is compared against
+// the value stored in the depth buffer.
+type DepthFunc int
+
+const (
+	DepthLess DepthFunc = iota
+	DepthLessEqual
+	DepthAlways
+	DepthNever
+)
+
+// ConfigWithDepthBuffer gives the back buffer a depth buffer matching its
+// size. It is cleared to +Inf.
+func ConfigWithDepthBuffer(r *Rasterizer) error {
+	r.backBuffer.depth = make([]float32, len(r.backBuffer.Image.Pix))
+	fillDepth(r.backBuffer.depth, float32(math.Inf(1)))
+	return nil
+}
+
+// WithDepthFunc sets the depth compare function of a draw call. The
+// default is DepthLess.
+func WithDepthFunc(fn DepthFunc) DrawOption {
+	return func(dc *drawCall) {
+		dc.depthFunc = fn
+	}
+}
+
+// WithDepthWrite controls if a draw call updates the depth buffer. Depth
+// writes are enabled by default.
+func WithDepthWrite(enabled bool) DrawOption {
+	return func(dc *drawCall) {
+		dc.depthWrite = enabled
+	}
+}
+
+// ClearDepth queues a clear of the depth buffer of the current render target
+// to depth. It is a no-op if the target has no depth buffer.
+func (r *Rasterizer) ClearDepth(depth float32) uint64 {
+	return r.submit(&drawCall{cmd: cmdClearDepth, depthClear: depth}, nil)
+}
+
+// depthTest compares z against the depth buffer of the target at offset i.
+// It always passes without a depth buffer.
+func depthTest(i int, z float32, dc *drawCall) bool {
+	depth := dc.target.depth
+	if depth == nil {
+		return true
+	}
+
+	switch dc.depthFunc {
+	case DepthLess:
+		return z < depth[i]
+	case DepthLessEqual:
+		return z <= depth[i]
+	case DepthAlways:
+		return true
+	}
+	return false
+}
+
+func depthWrite(i int, z float32, dc *drawCall) {
+	if dc.target.depth != nil && dc.depthWrite {
+		dc.target.depth[i] = z
+	}
+}
+
+func fillDepth(depth []float32, value float32) {
+	for i := range depth {
+		depth[i] = value
+	}
+}
@@ -0,0 +1,170 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+package rasterizer
+
+import (
+	"image"
+	"math"
+)
+
+// Vertices are snapped to a 28.4 fixed point grid.
+const (
+	subpixelBits  = 4
+	subpixelScale = 1 << subpixelBits
+	subpixelHalf  = subpixelScale / 2
+)
+
+// ConfigWithEdgeFunctions selects the edge function rasterizer instead of
+// the scanline rasterizer. It samples pixel centers against fixed point edge
+// functions and applies a strict top-left fill rule, so triangles sharing an
+// edge never overlap or leave cracks.
+func ConfigWithEdgeFunctions(r *Rasterizer) error {
+	r.edgeFunctions = true
+	return nil
+}
+
+type edgeFunction struct {
+	a, b int64 // F(x, y) = a*x + b*y + c
+	c    int64
+	bias int64
+}
+
+func newEdgeFunction(x0, y0, x1, y1 int64) edgeFunction {
+	dx, dy := x1-x0, y1-y0
+
+	// Pixels exactly on an edge belong to the triangle only if it is a top
+	// or a left edge.
+	var bias int64 = -1
+	if dy < 0 || (dy == 0 && dx > 0) {
+		bias = 0
+	}
+
+	return edgeFunction{a: -dy, b: dx, c: dy*x0 - dx*y0, bias: bias}
+}
+
+// evaluate returns the biased edge function at the center of pixel x, y.
+func (e *edgeFunction) evaluate(x, y int) int64 {
+	px := int64(x)*subpixelScale + subpixelHalf
+	py := int64(y)*subpixelScale + subpixelHalf
+	return e.a*px + e.b*py + e.c + e.bias
+}
+
+// limit narrows the inclusive range [xl, xr] to the pixels of row y that
+// are on the inside of the edge.
+func (e *edgeFunction) limit(y int, xl, xr *int) {
+	f := e.evaluate(*xl, y)
+	step := e.a * subpixelScale
+
+	switch {
+	case step > 0:
+		if f < 0 {
+			*xl += int((-f + step - 1) / step)
+		}
+	case step < 0:
+		if f < 0 {
+			*xr = *xl - 1
+		} else {
+			*xr = minInt(*xr, *xl+int(f/-step))
+		}
+	default:
+		if f < 0 {
+			*xr = *xl - 1
+		}
+	}
+}
+
+func snap(v float32) int64 {
+	return int64(math.Floor(float64(v)*subpixelScale + 0.5))
+}
+
+// rasterizeEdge rasterizes a triangle by intersecting the three edge
+// functions row by row. Coverage of a row is computed for the whole
+// triangle and only then clipped, so tiling does not affect the result.
+func (r *Rasterizer) rasterizeEdge(clip image.Rectangle, tri *triangle) (visited, written int) {
+	v0, v1, v2 := &tri.v[0], &tri.v[1], &tri.v[2]
+
+	x0, y0 := snap(v0.x), snap(v0.y)
+	x1, y1 := snap(v1.x), snap(v1.y)
+	x2, y2 := snap(v2.x), snap(v2.y)
+
+	area := (x1-x0)*(y2-y0) - (x2-x0)*(y1-y0)
+	if area == 0 {
+		return 0, 0
+	}
+
+	// Make the winding consistent so the inside of every edge is positive.
+	if area < 0 {
+		v1, v2 = v2, v1
+		x1, y1, x2, y2 = x2, y2, x1, y1
+		area = -area
+	}
+
+	edges := [3]edgeFunction{
+		newEdgeFunction(x1, y1, x2, y2),
+		newEdgeFunction(x2, y2, x0, y0),
+		newEdgeFunction(x0, y0, x1, y1),
+	}
+
+	// Attribute gradients in pixels, relative to the center of pixel 0, 0.
+	var (
+		base, dadx, dady varyings
+		fx0, fy0         = float32(x0) / subpixelScale, float32(y0) / subpixelScale
+		ex1, ey1         = float32(x1-x0) / subpixelScale, float32(y1-y0) / subpixelScale
+		ex2, ey2         = float32(x2-x0) / subpixelScale, float32(y2-y0) / subpixelScale
+		invArea          = 1 / (ex1*ey2 - ex2*ey1)
+	)
+
+	for i := range base {
+		d1 := v1.varyings[i] - v0.varyings[i]
+		d2 := v2.varyings[i] - v0.varyings[i]
+
+		dadx[i] = (d1*ey2 - d2*ey1) * invArea
+		dady[i] = (d2*ex1 - d1*ex2) * invArea
+		base[i] = v0.varyings[i] - dadx[i]*(fx0-0.5) - dady[i]*(fy0-0.5)
+	}
+
+	minX := int(minInt64(x0, minInt64(x1, x2)) >> subpixelBits)
+	maxX := int(maxInt64(x0, maxInt64(x1, x2)) >> subpixelBits)
+	minY := int(minInt64(y0, minInt64(y1, y2)) >> subpixelBits)
+	maxY := int(maxInt64(y0, maxInt64(y1, y2)) >> subpixelBits)
+
+	var sda, eda varyings
+
+	for y := maxInt(minY, clip.Min.Y); y <= minInt(maxY, clip.Max.Y-1); y++ {
+		xl, xr := minX, maxX
+		for i := range edges {
+			edges[i].limit(y, &xl, &xr)
+		}
+
+		if xl > xr || xr < clip.Min.X || xl >= clip.Max.X {
+			continue
+		}
+
+		for i := range sda {
+			row := base[i] + dady[i]*float32(y)
+			sda[i] = row + dadx[i]*float32(xl)
+			eda[i] = row + dadx[i]*float32(xr)
+		}
+
+		v, w := r.drawSpan(clip, y, float32(xl), float32(xr), &sda, &eda, tri)
+		visited, written = visited+v, written+w
+	}
+	return
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
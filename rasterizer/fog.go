@@ -0,0 +1,109 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+package rasterizer
+
+import (
+	"image/color"
+	"math"
+)
+
+const fogLevels = 32
+
+// FogMode selects how fog density grows with distance.
+type FogMode int
+
+const (
+	FogNone FogMode = iota
+	FogLinear
+	FogExponential
+)
+
+// Fog describes distance fog. Distance is measured as the clip space w of a
+// fragment, which is the view space depth for perspective projections.
+type Fog struct {
+	Mode FogMode
+
+	// Start and End are the distances where linear fog begins and where it
+	// fully covers the geometry.
+	Start, End float32
+
+	// Density is the rate of exponential fog.
+	Density float32
+
+	// Color of the fog. A nil color is black.
+	Color color.Color
+}
+
+// fogTable maps every palette index to the closest palette color of it
+// blended towards the fog color, for a number of fog levels.
+type fogTable [fogLevels][256]uint8
+
+// fogColor identifies the fog tables of a palette.
+type fogColor struct {
+	r, g, b, a uint32
+}
+
+func newFogTable(pal color.Palette, c color.Color) *fogTable {
+	var t fogTable
+
+	fr, fg, fb, _ := c.RGBA()
+	blend := func(c uint32, f uint32, amount float32) uint8 {
+		return uint8(float32(c>>8)*(1-amount) + float32(f>>8)*amount + 0.5)
+	}
+
+	for level := range t {
+		amount := float32(level) / (fogLevels - 1)
+		for i, pc := range pal {
+			r, g, b, _ := pc.RGBA()
+			t[level][i] = nearestIndex(pal, blend(r, fr, amount), blend(g, fg, amount), blend(b, fb, amount))
+		}
+	}
+	return &t
+}
+
+// amount returns how much of the fog color covers a fragment at distance,
+// in the range 0 to 1.
+func (f *Fog) amount(distance float32) float32 {
+	var a float32
+
+	switch f.Mode {
+	case FogLinear:
+		if f.End != f.Start {
+			a = (distance - f.Start) / (f.End - f.Start)
+		} else if distance >= f.End {
+			a = 1
+		}
+	case FogExponential:
+		a = 1 - float32(math.Exp(float64(-f.Density*distance)))
+	}
+
+	if a < 0 {
+		return 0
+	} else if a > 1 {
+		return 1
+	}
+	return a
+}
+
+// apply fogs the palette index of a fragment with the interpolated 1/w.
+func (f *Fog) apply(table *fogTable, index uint8, rw float32) uint8 {
+	level := int(f.amount(1/rw)*(fogLevels-1) + 0.5)
+	return table[level][index]
+}
+
+// SetFog sets the fog of draw calls submitted after the call. The fog
+// table is built from the palette of the render target by the geometry
+// stage and kept for a few fog colors per palette. Use a Fog with mode
+// FogNone to disable it.
+func (r *Rasterizer) SetFog(fog Fog) {
+	if fog.Color == nil {
+		fog.Color = color.Black
+	}
+
+	r.submitMutex.Lock()
+	r.fog = fog
+	r.submitMutex.Unlock()
+}
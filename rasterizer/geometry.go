@@ -7,12 +7,16 @@ package rasterizer
 
 import (
 	"image"
+	"runtime"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/andreas-jonsson/warp/platform"
+	"github.com/andreas-jonsson/drive/platform"
 	"github.com/ungerik/go3d/mat4"
 	"github.com/ungerik/go3d/vec2"
 	"github.com/ungerik/go3d/vec3"
+	"github.com/ungerik/go3d/vec4"
 )
 
 const (
@@ -20,173 +24,514 @@ const (
 	triangleBufferSize = 256
 )
 
+type command int
+
+const (
+	cmdDraw command = iota
+	cmdFence
+	cmdClearDepth
+)
+
+// Attributes interpolated across a triangle.
+const (
+	varyingZ = iota
+	varyingW
+	varyingU
+	varyingV
+	varyingLight
+	varyingIntensity
+	numVaryings
+)
+
+type varyings [numVaryings]float32
+
+type vertex struct {
+	x, y float32
+	varyings
+}
+
 type triangle struct {
-	a, b, c       vec3.T
-	uva, uvb, uvc vec2.T
-	texture       *image.Paletted
-	color         uint8
+	cmd   command
+	prim  primitive
+	id    uint64
+	v     [3]vertex
+	dc    *drawCall
+	color uint8
+
+	// Palette offset of the instance the triangle belongs to.
+	offset uint8
+
+	// Screen space gradients of u, v and w for mip level selection.
+	grad [3][2]float32
 }
 
 type drawCall struct {
-	id      uint64
-	vert    []vec3.T
-	uvs     []vec2.T
-	colors  []uint8
-	texture *image.Paletted
-	mvp     mat4.T
+	cmd      command
+	prim     primitive
+	fillMode FillMode
+	id       uint64
+	vert     []vec3.T
+	uvs      []vec2.T
+	colors   []uint8
+	indices  []uint16
+	topology Topology
+	texture  *Texture
+	mvp      mat4.T
+	mapping  TextureMapping
+
+	instances []Instance
+
+	normals  []vec3.T
+	model    mat4.T
+	lighting *lighting
+
+	shader   PixelShader
+	uniforms Uniforms
+
+	shadeTable *ShadeTable
+	light      float32
+	lights     []float32
+
+	intensities []float32
+
+	blendMode BlendMode
+	blend     *BlendTable
+
+	fog      Fog
+	fogTable *fogTable
+
+	blit *Blit
+
+	// Set if the triangles of the draw call are tested against and added to
+	// the span buffer.
+	occluder bool
+
+	debugMode DebugMode
+	heat      *heatTable
+
+	target    *RenderTarget
+	viewport  image.Rectangle
+	clip      image.Rectangle
+	cullMode  CullMode
+	frontFace Winding
+
+	depthFunc  DepthFunc
+	depthWrite bool
+	depthClear float32
 }
 
+// DrawOption changes the state of a single draw call.
+type DrawOption func(*drawCall)
+
+// Config changes the construction time configuration of a Rasterizer.
+type Config func(*Rasterizer) error
+
 type Rasterizer struct {
-	drawCallChan chan drawCall
+	// Accessed atomically, keep first for 64-bit alignment on 32-bit
+	// platforms.
+	retired uint64
+	stats   Stats
+
+	drawCallChan chan *drawCall
 	triangleChan chan triangle
 	workerWG     sync.WaitGroup
-	target       *image.Paletted
+	backBuffer   *RenderTarget
+	target       *RenderTarget
+	viewport     image.Rectangle
+	clips        clipStack
+	frontFace    Winding
+	fog          Fog
+	debugMode    DebugMode
+	lighting     *lighting
+	frameStats   Stats
+	capture      *Capture
+
+	edgeFunctions bool
+	spanBuffer    bool
+
+	submitMutex sync.Mutex
+
+	fenceMutex sync.Mutex
+	fenceCond  *sync.Cond
 }
 
-func NewRasterizer(backBuffer *image.Paletted) *Rasterizer {
+func NewRasterizer(backBuffer *image.Paletted, configs ...Config) (*Rasterizer, error) {
 	r := &Rasterizer{
-		target: backBuffer,
-		make(chan drawCall, triangleBufferSize),
-		make(chan triangle, triangleBufferSize),
+		backBuffer:   &RenderTarget{Image: backBuffer},
+		viewport:     backBuffer.Bounds(),
+		clips:        clipStack{bounds: backBuffer.Bounds()},
+		drawCallChan: make(chan *drawCall, drawCallBufferSize),
+		triangleChan: make(chan triangle, triangleBufferSize),
+	}
+	r.target = r.backBuffer
+	r.lighting = &lighting{ambient: 1}
+	r.fenceCond = sync.NewCond(&r.fenceMutex)
+
+	for _, cfg := range configs {
+		if err := cfg(r); err != nil {
+			return nil, err
+		}
 	}
 
-	workerWG.Add(2)
+	r.workerWG.Add(2)
 
 	go func() {
-		var tri triangle
+		var g geometryStage
 
 		for dc := range r.drawCallChan {
-			numVert := len(dc.vert)
-
-			for i := 0; i < numVert; i += 3 {
-				tri.a = dc.mvp.MulVec3(&dc.vert[i])
-				tri.b = dc.mvp.MulVec3(&dc.vert[i+1])
-				tri.c = dc.mvp.MulVec3(&dc.vert[i+2])
-
-				if dc.texture == nil {
-					tri.color = dc.colors[i/3]
-					multiSwap(&tri.a[0], &tri.a[1], &tri.b[0], &tri.b[1], &tri.c[0], &tri.c[1])
-				} else {
-					tri.uva = dc.uvs[i]
-					tri.uvb = dc.uvs[i+1]
-					tri.uvc = dc.uvs[i+2]
-					tri.texture = dc.texture
-
-					multiSwapUV(
-						&tri.a[0], &tri.a[1], &tri.b[0], &tri.b[1], &tri.c[0], &tri.c[1],
-						&tri.uva[0], &tri.uva[1], &tri.uvb[0], &tri.uvb[1], &tri.uvc[0], &tri.uvc[1],
-					)
-				}
-
-				r.triangleChan <- tri
+			if dc.cmd == cmdDraw {
+				start := time.Now()
+				submitted, clipped := r.processDrawCall(dc, &g)
+				r.stats.addGeometry(submitted, clipped, time.Since(start))
+			} else {
+				r.emit(triangle{cmd: dc.cmd, id: dc.id, dc: dc})
 			}
+
+			// Every draw call is terminated by a fence so the raster stage
+			// knows when all of its triangles have landed in the target.
+			r.emit(triangle{cmd: cmdFence, id: dc.id})
 		}
 
 		close(r.triangleChan)
 		r.workerWG.Done()
 	}()
 
+	numWorkers := runtime.GOMAXPROCS(0)
+	b := newBinner(r, numWorkers)
+	r.workerWG.Add(numWorkers)
+
+	for i := 0; i < numWorkers; i++ {
+		go func() {
+			b.worker()
+			r.workerWG.Done()
+		}()
+	}
+
 	go func() {
-		for tri := range r.triangleChan {
-			if tri.texture == nil {
-				r.rasterizeFlat(
-					int(tri.a[0]), int(tri.a[1]),
-					int(tri.b[0]), int(tri.b[1]),
-					int(tri.c[0]), int(tri.c[1]),
-					tri.color,
-				)
-			} else {
-				r.rasterizeTextured(
-					int(tri.a[0]), int(tri.a[1]),
-					int(tri.b[0]), int(tri.b[1]),
-					int(tri.c[0]), int(tri.c[1]),
-					tri.uva[0], tri.uva[1],
-					tri.uvb[0], tri.uvb[1],
-					tri.uvc[0], tri.uvc[1],
-					tri.texture,
-				)
-			}
-		}
+		b.run(r.triangleChan)
 		r.workerWG.Done()
 	}()
 
-	return r
+	return r, nil
 }
 
-func (r *Rasterizer) Wait(id uint64) {
-	//TODO Implement this!
-	panic("not implemented")
-}
+// geometryStage holds the scratch state of the geometry goroutine.
+type geometryStage struct {
+	clipper
 
-func (r *Rasterizer) Sync() {
-	r.Wait(r.DrawFlat(mat4.Ident, nil, nil))
+	// Clip space vertices of the current instance, valid if the stamp
+	// matches.
+	vertices []clipVertex
+	stamps   []uint64
+	stamp    uint64
+
+	// Screen space vertices of the current clipped polygon.
+	projected []vertex
+
+	// Transforms and light offset of the current instance.
+	mvp, model *mat4.T
+	light      float32
+
+	// Triangles of the current draw call waiting to be sorted.
+	sorted []triangle
+
+	tables tableCache
 }
 
-func (r *Rasterizer) Destroy() {
-	close(r.drawCallChan)
-	r.workerWG.Wait()
+// begin starts a new instance of a draw call. Vertices transformed for the
+// previous instance are invalidated.
+func (g *geometryStage) begin(mvp, model *mat4.T, light float32) {
+	g.stamp++
+	g.mvp = mvp
+	g.model = model
+	g.light = light
 }
 
-func (r *Rasterizer) DrawTextured(mvp *mat4.T, vert []vec3.T, uvs []vec2.T, texture *image.Paletted) uint64 {
-	id := platform.NewId64()
-	r.drawCallChan <- drawCall{id: id, mvp: mvp, vert: vert, uvs: uvs, texture: texture}
-	return id
+// vertex returns vertex i of dc in clip space. Every vertex is only
+// transformed once per instance, no matter how many triangles share it.
+func (g *geometryStage) vertex(dc *drawCall, i int) *clipVertex {
+	if len(g.vertices) < len(dc.vert) {
+		g.vertices = make([]clipVertex, len(dc.vert))
+		g.stamps = make([]uint64, len(dc.vert))
+	}
+
+	cv := &g.vertices[i]
+	if g.stamps[i] == g.stamp {
+		return cv
+	}
+	g.stamps[i] = g.stamp
+
+	pos := &dc.vert[i]
+	cv.pos = g.mvp.MulVec4(&vec4.T{pos[0], pos[1], pos[2], 1})
+	cv.varyings = varyings{}
+
+	if dc.texture != nil {
+		cv.varyings[varyingU] = dc.uvs[i][0]
+		cv.varyings[varyingV] = dc.uvs[i][1]
+	}
+
+	if dc.normals != nil {
+		cv.varyings[varyingLight] = dc.lighting.light(g.model, pos, &dc.normals[i]) + g.light
+	} else if dc.lights != nil {
+		cv.varyings[varyingLight] = dc.lights[i] + g.light
+	} else {
+		cv.varyings[varyingLight] = dc.light + g.light
+	}
+
+	if dc.intensities != nil {
+		cv.varyings[varyingIntensity] = dc.intensities[i]
+	}
+	return cv
 }
 
-func (r *Rasterizer) DrawFlat(mvp *mat4.T, vert []vec3.T, colors []uint8) uint64 {
-	id := platform.NewId64()
-	r.drawCallChan <- drawCall{id: id, mvp: mvp, vert: vert, colors: colors}
-	return id
+// project maps the vertices of a clipped polygon to the viewport of dc.
+func (g *geometryStage) project(dc *drawCall, poly []clipVertex) []vertex {
+	g.projected = g.projected[:0]
+	for i := range poly {
+		var v vertex
+		project(&poly[i], dc.viewport, &v)
+
+		// Perspective correct mapping interpolates u/w and v/w, the
+		// division is undone per pixel.
+		if dc.texture != nil && dc.mapping != MappingAffine {
+			v.varyings[varyingU] *= v.varyings[varyingW]
+			v.varyings[varyingV] *= v.varyings[varyingW]
+		}
+		g.projected = append(g.projected, v)
+	}
+	return g.projected
 }
 
-func swapInt(a, b *int) {
-	tmp := *a
-	*a = *b
-	*b = tmp
+// emit sends tri to the raster stage and records the time spent blocked if
+// the queue is full.
+func (r *Rasterizer) emit(tri triangle) {
+	select {
+	case r.triangleChan <- tri:
+	default:
+		start := time.Now()
+		r.triangleChan <- tri
+		addDuration(&r.stats.TriangleQueueStall, time.Since(start))
+	}
 }
 
-func swapFloat32(a, b *float32) {
-	tmp := *a
-	*a = *b
-	*b = tmp
+// processDrawCall transforms the vertices of dc to clip space, clips them
+// against the view frustum and sends the resulting screen space triangles to
+// the raster stage. It returns the number of triangles processed and how
+// many of them needed clipping.
+func (r *Rasterizer) processDrawCall(dc *drawCall, g *geometryStage) (submitted, clipped int) {
+	g.tables.resolve(dc)
+	g.begin(&dc.mvp, &dc.model, 0)
+
+	switch dc.prim {
+	case primLine:
+		r.processLines(dc, g)
+		return
+	case primPoint:
+		r.processPoints(dc, g)
+		return
+	case primBlit:
+		r.processBlit(dc)
+		return
+	}
+
+	if dc.instances == nil {
+		submitted, clipped = r.processTriangles(dc, g, 0)
+	} else {
+		for i := range dc.instances {
+			inst := &dc.instances[i]
+
+			model := &inst.Model
+			if *model == (mat4.T{}) {
+				model = &mat4.Ident
+			}
+			g.begin(&inst.MVP, model, inst.Light)
+
+			s, c := r.processTriangles(dc, g, inst.ColorOffset)
+			submitted, clipped = submitted+s, clipped+c
+		}
+	}
+
+	// Occluders are sorted over all instances of the draw call.
+	if dc.occluder {
+		sortFrontToBack(g.sorted)
+		for _, t := range g.sorted {
+			r.emit(t)
+		}
+		g.sorted = g.sorted[:0]
+	}
+	return
 }
 
-func multiSwap(x0, y0, x1, y1, x2, y2 *int) {
-	if *y1 < *y0 {
-		swapInt(y1, y0)
-		swapInt(x1, x0)
+// processTriangles clips and projects the triangles of the current
+// instance of dc. offset is added to the palette index of every pixel.
+func (r *Rasterizer) processTriangles(dc *drawCall, g *geometryStage, offset uint8) (numTriangles, clipped int) {
+	tri := triangle{cmd: cmdDraw, id: dc.id, dc: dc, offset: offset}
+	numTriangles = dc.numTriangles()
+
+	for prim := 0; prim < numTriangles; prim++ {
+		i0, i1, i2 := dc.triangleIndices(prim)
+
+		// Gouraud shaded draw calls may leave out the colors.
+		if dc.texture == nil && dc.colors != nil {
+			tri.color = dc.colors[prim]
+		}
+
+		a, b, c := g.vertex(dc, i0), g.vertex(dc, i1), g.vertex(dc, i2)
+		if outcode(&a.pos)|outcode(&b.pos)|outcode(&c.pos) != 0 {
+			clipped++
+		}
+
+		poly := g.clip(a, b, c)
+		if len(poly) < 3 {
+			continue
+		}
+
+		verts := g.project(dc, poly)
+		tri.v[0] = verts[0]
+		for j := 2; j < len(verts); j++ {
+			tri.v[1], tri.v[2] = verts[j-1], verts[j]
+
+			// The clipped polygon is planar so the first triangle of the
+			// fan decides the facing of all of them.
+			if j == 2 && dc.cullMode != CullNone && cull(&tri, dc) {
+				atomic.AddUint64(&r.stats.TrianglesCulled, 1)
+				break
+			}
+
+			if dc.fillMode == FillWireframe {
+				r.emitLine(dc, tri.color+offset, a, b)
+				r.emitLine(dc, tri.color+offset, b, c)
+				r.emitLine(dc, tri.color+offset, c, a)
+				break
+			}
+
+			if dc.texture != nil && dc.texture.Levels() > 1 {
+				textureGradients(&tri)
+			}
+
+			if dc.occluder {
+				g.sorted = append(g.sorted, tri)
+			} else {
+				r.emit(tri)
+			}
+		}
 	}
 
-	if *y2 < *y0 {
-		swapInt(y2, y0)
-		swapInt(x2, x0)
+	return
+}
+
+// retire marks id, and implicitly every id issued before it, as fully
+// rasterized and wakes up anyone blocking in Wait.
+func (r *Rasterizer) retire(id uint64) {
+	r.fenceMutex.Lock()
+	if id+1 > r.retired {
+		atomic.StoreUint64(&r.retired, id+1)
 	}
+	r.fenceMutex.Unlock()
+	r.fenceCond.Broadcast()
+}
 
-	if *y1 < *y2 {
-		swapInt(y2, y1)
-		swapInt(x2, x1)
+// Done reports whether the draw call identified by id, and all draw calls
+// submitted before it, have been rasterized. It never blocks.
+func (r *Rasterizer) Done(id uint64) bool {
+	return atomic.LoadUint64(&r.retired) > id
+}
+
+// Wait blocks until the draw call identified by id, and all draw calls
+// submitted before it, have been rasterized.
+func (r *Rasterizer) Wait(id uint64) {
+	r.fenceMutex.Lock()
+	for r.retired <= id {
+		r.fenceCond.Wait()
 	}
+	r.fenceMutex.Unlock()
+}
+
+// Sync blocks until everything submitted so far has been rasterized.
+func (r *Rasterizer) Sync() {
+	r.Wait(r.submit(&drawCall{cmd: cmdFence}, nil))
+}
+
+func (r *Rasterizer) Destroy() {
+	close(r.drawCallChan)
+	r.workerWG.Wait()
 }
 
-func multiSwapUV(x0, y0, x1, y1, x2, y2 *int, u0, v0, u1, v1, u2, v2 *float32) {
-	if *y1 < *y0 {
-		swapInt(y1, y0)
-		swapInt(x1, x0)
-		swapFloat32(u1, u0)
-		swapFloat32(v1, v0)
+// submit applies opts to dc, allocates an id for it and queues it.
+// Allocation and queueing are done atomically so ids always reach the
+// pipeline in increasing order.
+func (r *Rasterizer) submit(dc *drawCall, opts []DrawOption) uint64 {
+	dc.depthFunc = DepthLess
+	dc.depthWrite = true
+	dc.light = 1
+	dc.model = mat4.Ident
+
+	for _, opt := range opts {
+		opt(dc)
+	}
+
+	// Draw calls with an empty texture are rejected, they only retire their
+	// id.
+	if dc.texture != nil && dc.texture.empty() {
+		dc.cmd = cmdFence
 	}
 
-	if *y2 < *y0 {
-		swapInt(y2, y0)
-		swapInt(x2, x0)
-		swapFloat32(u2, u0)
-		swapFloat32(v2, v0)
+	dc.uniforms.Texture = dc.texture
+	if dc.shader == nil {
+		if dc.texture != nil {
+			dc.shader = texturedShader{}
+		} else if dc.uniforms.Ramp != nil {
+			dc.shader = gouraudShader{}
+		} else {
+			dc.shader = flatShader{}
+		}
 	}
 
-	if *y1 < *y2 {
-		swapInt(y2, y1)
-		swapInt(x2, x1)
-		swapFloat32(u2, u1)
-		swapFloat32(v2, v1)
+	r.submitMutex.Lock()
+	dc.target = r.target
+	dc.viewport = r.viewport
+	dc.clip = r.clips.top()
+	dc.fog = r.fog
+	dc.lighting = r.lighting
+	dc.frontFace = r.frontFace
+
+	dc.debugMode = r.debugMode
+
+	dc.occluder = r.spanBuffer && dc.opaque()
+	dc.id = platform.NewId64()
+
+	if r.capture != nil {
+		r.capture.record(dc)
 	}
+
+	select {
+	case r.drawCallChan <- dc:
+	default:
+		start := time.Now()
+		r.drawCallChan <- dc
+		addDuration(&r.stats.DrawCallQueueStall, time.Since(start))
+	}
+	r.submitMutex.Unlock()
+	return dc.id
+}
+
+// DrawTextured queues a list of textured triangles. The vertices are
+// transformed by mvp into clip space, clipped to the view frustum and mapped
+// to the viewport. uvs holds one texture coordinate per vertex. Nothing is
+// drawn if the texture is empty.
+func (r *Rasterizer) DrawTextured(mvp *mat4.T, vert []vec3.T, uvs []vec2.T, texture *image.Paletted, opts ...DrawOption) uint64 {
+	return r.submit(&drawCall{mvp: *mvp, vert: vert, uvs: uvs, texture: NewTextureLevels(texture)}, opts)
+}
+
+// DrawMipmapped is like DrawTextured but samples a mipmapped texture. The
+// mip level is chosen per span from the texture coordinate derivatives.
+func (r *Rasterizer) DrawMipmapped(mvp *mat4.T, vert []vec3.T, uvs []vec2.T, texture *Texture, opts ...DrawOption) uint64 {
+	return r.submit(&drawCall{mvp: *mvp, vert: vert, uvs: uvs, texture: texture}, opts)
+}
+
+// DrawFlat queues a list of flat shaded triangles. The vertices are
+// transformed by mvp into clip space, clipped to the view frustum and mapped
+// to the viewport. colors holds one palette index per triangle.
+func (r *Rasterizer) DrawFlat(mvp *mat4.T, vert []vec3.T, colors []uint8, opts ...DrawOption) uint64 {
+	return r.submit(&drawCall{mvp: *mvp, vert: vert, colors: colors}, opts)
 }
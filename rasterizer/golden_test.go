@@ -0,0 +1,315 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+package rasterizer_test
+
+import (
+	"flag"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andreas-jonsson/drive/rasterizer"
+	"github.com/ungerik/go3d/mat4"
+	"github.com/ungerik/go3d/vec2"
+	"github.com/ungerik/go3d/vec3"
+)
+
+var update = flag.Bool("update", false, "update the golden images in testdata")
+
+const (
+	goldenWidth  = 64
+	goldenHeight = 64
+)
+
+var rasterizerModes = []struct {
+	name    string
+	configs []rasterizer.Config
+}{
+	{"scanline", nil},
+	{"edge", []rasterizer.Config{rasterizer.ConfigWithEdgeFunctions}},
+}
+
+var goldenScenes = []struct {
+	name  string
+	scene func(r *rasterizer.Rasterizer)
+}{
+	{"flat", sceneFlat},
+	{"textured", sceneTextured},
+	{"degenerate", sceneDegenerate},
+	{"offscreen", sceneOffscreen},
+	{"clipped_textured", sceneClippedTextured},
+	{"shared_edges", sceneSharedEdges},
+}
+
+func TestGolden(t *testing.T) {
+	for _, mode := range rasterizerModes {
+		for _, scene := range goldenScenes {
+			name := scene.name + "_" + mode.name
+			configs, fn := mode.configs, scene.scene
+
+			t.Run(name, func(t *testing.T) {
+				checkGolden(t, name, render(t, configs, fn))
+			})
+		}
+	}
+}
+
+// TestSharedEdges checks that the edge function rasterizer covers every
+// pixel of a triangle fan exactly once. The triangles are rendered one by
+// one and their coverage is summed, so overlaps show up as 2 and cracks as 0.
+func TestSharedEdges(t *testing.T) {
+	var coverage [goldenHeight][goldenWidth]int
+
+	vert, _ := fan(image.Pt(32, 32), 28, 13)
+	for i := 0; i < len(vert); i += 3 {
+		img := render(t, rasterizerModes[1].configs, func(r *rasterizer.Rasterizer) {
+			r.DrawFlat(pixelSpace(), vert[i:i+3], []uint8{1})
+		})
+
+		for y := range coverage {
+			for x := range coverage[y] {
+				if img.ColorIndexAt(x, y) != 0 {
+					coverage[y][x]++
+				}
+			}
+		}
+	}
+
+	for y := 8; y < 56; y++ {
+		for x := 8; x < 56; x++ {
+			if dx, dy := x-32, y-32; dx*dx+dy*dy > 20*20 {
+				continue
+			}
+			if n := coverage[y][x]; n != 1 {
+				t.Errorf("pixel %d,%d covered %d times", x, y, n)
+			}
+		}
+	}
+}
+
+func render(t *testing.T, configs []rasterizer.Config, scene func(r *rasterizer.Rasterizer)) *image.Paletted {
+	img := image.NewPaletted(image.Rect(0, 0, goldenWidth, goldenHeight), palette.Plan9)
+
+	r, err := rasterizer.NewRasterizer(img, configs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Destroy()
+
+	scene(r)
+	r.Sync()
+	return img
+}
+
+// checkGolden compares img against testdata/name.png. Mismatching pixels
+// are logged and written to testdata/name.diff.png.
+func checkGolden(t *testing.T, name string, img *image.Paletted) {
+	path := filepath.Join("testdata", name+".png")
+	diffPath := filepath.Join("testdata", name+".diff.png")
+
+	if *update {
+		if err := writePNG(path, img); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	fp, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("%v, run with -update to create it", err)
+	}
+	defer fp.Close()
+
+	golden, err := png.Decode(fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if golden.Bounds() != img.Bounds() {
+		t.Fatalf("golden image is %v, rendered image is %v", golden.Bounds(), img.Bounds())
+	}
+
+	var (
+		bounds = img.Bounds()
+		diff   = image.NewGray(bounds)
+		count  int
+	)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r0, g0, b0, _ := golden.At(x, y).RGBA()
+			r1, g1, b1, _ := img.At(x, y).RGBA()
+			if r0 == r1 && g0 == g1 && b0 == b1 {
+				continue
+			}
+
+			diff.SetGray(x, y, color.Gray{255})
+			if count++; count <= 10 {
+				t.Errorf("pixel %d,%d is %v, want %v", x, y, img.At(x, y), golden.At(x, y))
+			}
+		}
+	}
+
+	if count == 0 {
+		os.Remove(diffPath)
+		return
+	}
+
+	t.Errorf("%d pixels differ, see %s", count, diffPath)
+	if err := writePNG(diffPath, diff); err != nil {
+		t.Error(err)
+	}
+}
+
+func writePNG(path string, img image.Image) error {
+	fp, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	return png.Encode(fp, img)
+}
+
+// pixelSpace returns a matrix mapping pixel coordinates of the golden image
+// to clip space.
+func pixelSpace() *mat4.T {
+	return &mat4.T{
+		{2 / float32(goldenWidth), 0, 0, 0},
+		{0, -2 / float32(goldenHeight), 0, 0},
+		{0, 0, 1, 0},
+		{-1, 1, 0, 1},
+	}
+}
+
+// perspective returns a projection with a 90 degree field of view looking
+// down the negative z axis.
+func perspective(near, far float32) *mat4.T {
+	return &mat4.T{
+		{1, 0, 0, 0},
+		{0, 1, 0, 0},
+		{0, 0, (far + near) / (near - far), -1},
+		{0, 0, 2 * far * near / (near - far), 0},
+	}
+}
+
+// fan returns a triangle list of n triangles around center, sharing all
+// inner edges, and one color per triangle.
+func fan(center image.Point, radius float32, n int) ([]vec3.T, []uint8) {
+	var (
+		vert   []vec3.T
+		colors []uint8
+	)
+
+	// Integer steps around a square keep the vertices exact.
+	var outline []vec3.T
+	cx, cy := float32(center.X), float32(center.Y)
+	for i := 0; i < n; i++ {
+		s := float32(i)/float32(n)*8 - 4
+		var x, y float32
+		switch {
+		case s < -2:
+			x, y = -1, s+3
+		case s < 0:
+			x, y = s+1, 1
+		case s < 2:
+			x, y = 1, 1-s
+		default:
+			x, y = 3-s, -1
+		}
+		outline = append(outline, vec3.T{cx + x*radius, cy + y*radius, 0})
+	}
+
+	for i := range outline {
+		vert = append(vert, vec3.T{cx + 0.25, cy + 0.5, 0}, outline[i], outline[(i+1)%n])
+		colors = append(colors, uint8(16+i*12))
+	}
+	return vert, colors
+}
+
+func checkerboard(size, cell int) *image.Paletted {
+	img := image.NewPaletted(image.Rect(0, 0, size, size), palette.Plan9)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			index := uint8(40)
+			if (x/cell+y/cell)%2 == 1 {
+				index = 200
+			}
+			img.SetColorIndex(x, y, index)
+		}
+	}
+	return img
+}
+
+func sceneFlat(r *rasterizer.Rasterizer) {
+	r.DrawFlat(pixelSpace(), []vec3.T{
+		{4, 4, 0}, {40, 8, 0}, {10, 30, 0},
+		{60, 10.5, 0}, {50.5, 60, 0}, {20, 40, 0},
+		{2, 62, 0}, {2, 40, 0}, {30, 62, 0},
+	}, []uint8{60, 120, 180})
+
+	r.DrawFlat(perspective(1, 100), []vec3.T{
+		{-1, -1, -2}, {1, -1, -2}, {0, 0.5, -6},
+	}, []uint8{230})
+}
+
+func sceneTextured(r *rasterizer.Rasterizer) {
+	texture := checkerboard(16, 4)
+	quad := []vec3.T{{-2, -1, -2}, {2, -1, -2}, {2, -1, -20}, {-2, -1, -2}, {2, -1, -20}, {-2, -1, -20}}
+	uvs := []vec2.T{{0, 0}, {1, 0}, {1, 4}, {0, 0}, {1, 4}, {0, 4}}
+
+	sampler := rasterizer.Sampler{AddressU: rasterizer.AddressRepeat, AddressV: rasterizer.AddressRepeat}
+	r.DrawTextured(perspective(1, 100), quad, uvs, texture,
+		rasterizer.WithSampler(sampler),
+		rasterizer.WithTextureMapping(rasterizer.MappingPerspective))
+
+	r.DrawTextured(pixelSpace(), []vec3.T{{4, 4, 0}, {28, 4, 0}, {4, 28, 0}}, []vec2.T{{0, 0}, {1, 0}, {0, 1}}, texture)
+}
+
+func sceneDegenerate(r *rasterizer.Rasterizer) {
+	r.DrawFlat(pixelSpace(), []vec3.T{
+		{10, 10, 0}, {10, 10, 0}, {10, 10, 0}, // A point.
+		{5, 20, 0}, {30, 20, 0}, {55, 20, 0}, // Horizontal line.
+		{40, 5, 0}, {40, 30, 0}, {40, 60, 0}, // Vertical line.
+		{5, 35, 0}, {20, 45, 0}, {35, 55, 0}, // Diagonal line.
+		{50, 50, 0}, {50.2, 50.2, 0}, {50.4, 50, 0}, // Sub pixel sliver.
+	}, []uint8{30, 60, 90, 120, 150})
+}
+
+func sceneOffscreen(r *rasterizer.Rasterizer) {
+	r.DrawFlat(pixelSpace(), []vec3.T{
+		{-40, -40, 0}, {-10, -40, 0}, {-40, -10, 0}, // Fully outside.
+		{100, 10, 0}, {200, 10, 0}, {100, 50, 0}, // Fully outside.
+		{-30, 20, 0}, {30, 32, 0}, {-30, 50, 0}, // Crossing the left edge.
+		{40, -30, 0}, {100, 30, 0}, {40, 90, 0}, // Crossing three edges.
+	}, []uint8{30, 60, 90, 120})
+
+	// Crosses the near plane and the camera plane.
+	r.DrawFlat(perspective(1, 100), []vec3.T{
+		{-1, 0.2, 2}, {1, 0.2, 2}, {0, 0.8, -10},
+	}, []uint8{200})
+}
+
+func sceneClippedTextured(r *rasterizer.Rasterizer) {
+	texture := checkerboard(16, 4)
+
+	// Crosses the near plane and both side planes.
+	quad := []vec3.T{{-6, -1, 1}, {6, -1, 1}, {6, -1, -12}, {-6, -1, 1}, {6, -1, -12}, {-6, -1, -12}}
+	uvs := []vec2.T{{0, 0}, {3, 0}, {3, 3}, {0, 0}, {3, 3}, {0, 3}}
+
+	sampler := rasterizer.Sampler{AddressU: rasterizer.AddressRepeat, AddressV: rasterizer.AddressRepeat}
+	r.DrawTextured(perspective(1, 100), quad, uvs, texture,
+		rasterizer.WithSampler(sampler),
+		rasterizer.WithTextureMapping(rasterizer.MappingPerspective))
+}
+
+func sceneSharedEdges(r *rasterizer.Rasterizer) {
+	vert, colors := fan(image.Pt(32, 32), 28, 13)
+	r.DrawFlat(pixelSpace(), vert, colors)
+}
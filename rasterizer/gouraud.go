@@ -0,0 +1,66 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+package rasterizer
+
+// 4x4 Bayer matrix normalized to thresholds in the range 0 to 1.
+var bayer4 = [4][4]float32{
+	{0.5 / 16, 8.5 / 16, 2.5 / 16, 10.5 / 16},
+	{12.5 / 16, 4.5 / 16, 14.5 / 16, 6.5 / 16},
+	{3.5 / 16, 11.5 / 16, 1.5 / 16, 9.5 / 16},
+	{15.5 / 16, 7.5 / 16, 13.5 / 16, 5.5 / 16},
+}
+
+// DitherThreshold returns the ordered dither threshold, in the range 0 to 1,
+// of pixel x, y.
+func DitherThreshold(x, y int) float32 {
+	return bayer4[y&3][x&3]
+}
+
+// WithGouraud makes a flat draw call interpolate one intensity per vertex,
+// in the range 0 to 1, and map it onto ramp. The ramp is a list of palette
+// indices going from dark to bright. The colors of the draw call are not
+// used and may be nil.
+func WithGouraud(intensities []float32, ramp []uint8) DrawOption {
+	return func(dc *drawCall) {
+		dc.intensities = intensities
+		dc.uniforms.Ramp = ramp
+	}
+}
+
+// WithDither enables ordered dithering between the entries of the Gouraud
+// ramp, hiding the banding of short ramps.
+func WithDither(enabled bool) DrawOption {
+	return func(dc *drawCall) {
+		dc.uniforms.Dither = enabled
+	}
+}
+
+type gouraudShader struct{}
+
+func (gouraudShader) Shade(x, y int, in *Attributes, uniforms *Uniforms) (uint8, bool) {
+	ramp := uniforms.Ramp
+	pos := in.Intensity * float32(len(ramp)-1)
+
+	if uniforms.Dither {
+		pos += DitherThreshold(x, y)
+	} else {
+		pos += 0.5
+	}
+
+	i := int(pos)
+	if i < 0 {
+		i = 0
+	} else if i >= len(ramp) {
+		i = len(ramp) - 1
+	}
+	return ramp[i], true
+}
+
+// NewDefaultGouraudShader returns the shader used by draw calls with
+// WithGouraud. It maps the intensity onto the ramp, optionally dithered.
+func NewDefaultGouraudShader() PixelShader {
+	return gouraudShader{}
+}
@@ -0,0 +1,43 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+package rasterizer
+
+import "github.com/ungerik/go3d/mat4"
+
+// Instance is the per instance state of an instanced draw call.
+type Instance struct {
+	MVP mat4.T
+
+	// Model transforms the mesh into the space of the lights. It is only
+	// used if the mesh has normals. The zero matrix is treated as the
+	// identity matrix.
+	Model mat4.T
+
+	// ColorOffset is added to the palette index of every pixel of the
+	// instance, before shading and blending. It selects another color ramp
+	// for flat meshes or another part of the palette for textures.
+	ColorOffset uint8
+
+	// Light is added to the light level of the draw call, e.g. to darken
+	// distant instances. It requires a shade table.
+	Light float32
+}
+
+// DrawInstanced queues one copy of mesh per instance, each with its own
+// transform. The copies are expanded by the geometry stage, so the whole
+// set costs a single draw call. The mesh is flat shaded if texture is nil.
+func (r *Rasterizer) DrawInstanced(mesh *Mesh, texture *Texture, instances []Instance, opts ...DrawOption) uint64 {
+	return r.submit(&drawCall{
+		topology:  mesh.Topology,
+		vert:      mesh.Vertices,
+		uvs:       mesh.UVs,
+		colors:    mesh.Colors,
+		indices:   mesh.Indices,
+		normals:   mesh.Normals,
+		texture:   texture,
+		instances: instances,
+	}, opts)
+}
@@ -0,0 +1,181 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+package rasterizer
+
+import (
+	"math"
+
+	"github.com/ungerik/go3d/mat4"
+	"github.com/ungerik/go3d/vec3"
+)
+
+// LightType selects how a light is emitted.
+type LightType int
+
+const (
+	// LightDirectional lights everything from the same direction, like the
+	// sun.
+	LightDirectional LightType = iota
+
+	// LightPoint emits light in all directions from a position.
+	LightPoint
+
+	// LightSpot emits a cone of light from a position, like a headlight.
+	LightSpot
+)
+
+// Light is a dynamic light. Positions and directions are in the space the
+// model matrix of a draw call transforms its vertices to, usually world
+// space.
+type Light struct {
+	Type      LightType
+	Intensity float32
+
+	// Position of point and spot lights.
+	Position vec3.T
+
+	// Direction the light travels in, for directional and spot lights.
+	Direction vec3.T
+
+	// Range is the distance where point and spot lights have faded to
+	// zero. Zero means the light does not fade with distance.
+	Range float32
+
+	// InnerAngle and OuterAngle are the half angles of a spot light cone,
+	// in radians. The light is at full intensity inside the inner cone and
+	// fades to zero at the outer cone.
+	InnerAngle, OuterAngle float32
+}
+
+// Levels of the shade table used by lit draw calls without one. Light
+// levels above 1 brighten towards white, the odd level count puts light 1
+// exactly on the unscaled palette.
+const (
+	defaultShadeLevels    = 33
+	defaultShadeBrightest = 2
+)
+
+// sceneLight is a light with values precomputed for vertex evaluation.
+type sceneLight struct {
+	Light
+	cosInner, cosOuter float32
+}
+
+type lighting struct {
+	ambient float32
+	lights  []sceneLight
+}
+
+// SetLights sets the ambient light level and the dynamic lights of draw
+// calls submitted after the call. Only draw calls with normals are lit, the
+// light of every vertex is the ambient level plus the Lambert term of all
+// lights. The result is interpolated and resolved through the shade table
+// of the draw call. Lit draw calls without WithShadeTable use a table built
+// from the palette of the render target.
+func (r *Rasterizer) SetLights(ambient float32, lights ...Light) {
+	l := &lighting{ambient: ambient, lights: make([]sceneLight, len(lights))}
+
+	for i, light := range lights {
+		sl := &l.lights[i]
+		sl.Light = light
+		sl.Direction.Normalize()
+		sl.cosInner = float32(math.Cos(float64(light.InnerAngle)))
+		sl.cosOuter = float32(math.Cos(float64(light.OuterAngle)))
+	}
+
+	r.submitMutex.Lock()
+	r.lighting = l
+	r.submitMutex.Unlock()
+}
+
+// WithNormals sets one normal per vertex and enables lighting of the draw
+// call. Meshes carry their own normals.
+func WithNormals(normals []vec3.T) DrawOption {
+	return func(dc *drawCall) {
+		dc.normals = normals
+	}
+}
+
+// WithModel sets the matrix that transforms vertices and normals into the
+// space of the lights. It must not scale non-uniformly. The default is the
+// identity matrix.
+func WithModel(model *mat4.T) DrawOption {
+	return func(dc *drawCall) {
+		dc.model = *model
+	}
+}
+
+// light evaluates the light level of a vertex at pos with normal n, both in
+// model space.
+func (l *lighting) light(model *mat4.T, pos, n *vec3.T) float32 {
+	p := transformPoint(model, pos)
+	normal := transformDirection(model, n)
+	normal.Normalize()
+
+	light := l.ambient
+	for i := range l.lights {
+		light += l.lights[i].lambert(&p, &normal)
+	}
+	return light
+}
+
+// lambert returns the diffuse light sl contributes to a point p with the
+// unit normal n.
+func (sl *sceneLight) lambert(p, n *vec3.T) float32 {
+	if sl.Type == LightDirectional {
+		return sl.Intensity * maxFloat(-vec3.Dot(n, &sl.Direction), 0)
+	}
+
+	dir := vec3.Sub(&sl.Position, p)
+	dist := dir.Length()
+	if dist == 0 {
+		return sl.Intensity
+	}
+	dir = vec3.T{dir[0] / dist, dir[1] / dist, dir[2] / dist}
+
+	intensity := sl.Intensity
+	if sl.Range > 0 {
+		intensity *= 1 - dist/sl.Range
+		if intensity <= 0 {
+			return 0
+		}
+	}
+
+	if sl.Type == LightSpot {
+		cos := -vec3.Dot(&dir, &sl.Direction)
+		if cos <= sl.cosOuter {
+			return 0
+		}
+		if cos < sl.cosInner {
+			intensity *= (cos - sl.cosOuter) / (sl.cosInner - sl.cosOuter)
+		}
+	}
+
+	return intensity * maxFloat(vec3.Dot(n, &dir), 0)
+}
+
+func transformPoint(m *mat4.T, v *vec3.T) vec3.T {
+	var p vec3.T
+	for i := range p {
+		p[i] = m[0][i]*v[0] + m[1][i]*v[1] + m[2][i]*v[2] + m[3][i]
+	}
+	return p
+}
+
+func transformDirection(m *mat4.T, v *vec3.T) vec3.T {
+	var d vec3.T
+	for i := range d {
+		d[i] = m[0][i]*v[0] + m[1][i]*v[1] + m[2][i]*v[2]
+	}
+	return d
+}
+
+func maxFloat(a, b float32) float32 {
+	if a > b {
+		return a
+	}
+	return b
+}
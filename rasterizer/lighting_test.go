@@ -0,0 +1,145 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+package rasterizer_test
+
+import (
+	"image"
+	"image/color"
+	"image/color/palette"
+	"testing"
+
+	"github.com/andreas-jonsson/drive/rasterizer"
+	"github.com/ungerik/go3d/vec3"
+)
+
+const litColor = 255
+
+var lightingTests = []struct {
+	name           string
+	lights         []rasterizer.Light
+	center, corner float32
+}{
+	{"ambient", nil, 0.25, 0.25},
+	{"directional", []rasterizer.Light{{
+		Type:      rasterizer.LightDirectional,
+		Intensity: 0.5,
+		Direction: vec3.T{0, 0, -1},
+	}}, 0.75, 0.75},
+	{"spot", []rasterizer.Light{{
+		Type:       rasterizer.LightSpot,
+		Intensity:  0.75,
+		Position:   vec3.T{32, 32, 40},
+		Direction:  vec3.T{0, 0, -1},
+		InnerAngle: 0.3,
+		OuterAngle: 0.5,
+	}}, 1, 0.25},
+}
+
+// TestLighting checks the shade level of a lit grid facing the camera, in
+// the center and in a corner outside of the spot light cone.
+func TestLighting(t *testing.T) {
+	table := rasterizer.NewShadeTable(palette.Plan9, 16, 1)
+	mesh := litGrid()
+
+	for _, mode := range rasterizerModes {
+		for _, test := range lightingTests {
+			img := render(t, mode.configs, func(r *rasterizer.Rasterizer) {
+				r.SetLights(0.25, test.lights...)
+				r.DrawMesh(pixelSpace(), mesh, nil, rasterizer.WithShadeTable(table))
+			})
+
+			if got, want := img.ColorIndexAt(32, 32), table.Shade(litColor, test.center); got != want {
+				t.Errorf("%s %s: center is %d, want %d", mode.name, test.name, got, want)
+			}
+			if got, want := img.ColorIndexAt(4, 4), table.Shade(litColor, test.corner); got != want {
+				t.Errorf("%s %s: corner is %d, want %d", mode.name, test.name, got, want)
+			}
+		}
+	}
+}
+
+// TestLightingDefaults checks that lit draw calls without a shade table are
+// still lit, and that instances without a model matrix are lit like the
+// plain mesh.
+func TestLightingDefaults(t *testing.T) {
+	mesh := litGrid()
+	spot := lightingTests[2].lights
+
+	lit := render(t, nil, func(r *rasterizer.Rasterizer) {
+		r.SetLights(0.25, spot...)
+		r.DrawMesh(pixelSpace(), mesh, nil)
+	})
+	if lit.ColorIndexAt(32, 32) == lit.ColorIndexAt(4, 4) {
+		t.Errorf("spot light has no effect without a shade table")
+	}
+
+	instanced := render(t, nil, func(r *rasterizer.Rasterizer) {
+		r.SetLights(0.25, spot...)
+		r.DrawInstanced(mesh, nil, []rasterizer.Instance{{MVP: *pixelSpace()}})
+	})
+	for i := range lit.Pix {
+		if lit.Pix[i] != instanced.Pix[i] {
+			t.Fatalf("instanced pixel %d,%d is %d, want %d", i%goldenWidth, i/goldenWidth, instanced.Pix[i], lit.Pix[i])
+		}
+	}
+}
+
+// TestDefaultShadeTable checks that a light level of 1 leaves the colors of
+// a lit draw call without a shade table unchanged. The gray palette shows
+// even a slight brightening.
+func TestDefaultShadeTable(t *testing.T) {
+	gray := make(color.Palette, 256)
+	for i := range gray {
+		gray[i] = color.Gray{uint8(i)}
+	}
+
+	lit := litGrid()
+	for i := range lit.Colors {
+		lit.Colors[i] = uint8(i * 2)
+	}
+	unlit := *lit
+	unlit.Normals = nil
+
+	draw := func(mesh *rasterizer.Mesh) *image.Paletted {
+		img := image.NewPaletted(image.Rect(0, 0, goldenWidth, goldenHeight), gray)
+		r, err := rasterizer.NewRasterizer(img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Destroy()
+
+		r.SetLights(1)
+		r.DrawMesh(pixelSpace(), mesh, nil)
+		r.Sync()
+		return img
+	}
+
+	comparePixels(t, "default shade table", draw(lit), draw(&unlit))
+}
+
+// litGrid returns a grid of 8x8 pixel quads covering the golden image, with
+// normals facing the camera.
+func litGrid() *rasterizer.Mesh {
+	const cells = 8
+	mesh := &rasterizer.Mesh{}
+
+	for y := 0; y <= cells; y++ {
+		for x := 0; x <= cells; x++ {
+			p := image.Pt(x*goldenWidth/cells, y*goldenHeight/cells)
+			mesh.Vertices = append(mesh.Vertices, vec3.T{float32(p.X), float32(p.Y), 0})
+			mesh.Normals = append(mesh.Normals, vec3.T{0, 0, 1})
+		}
+	}
+
+	for y := 0; y < cells; y++ {
+		for x := 0; x < cells; x++ {
+			i := uint16(y*(cells+1) + x)
+			mesh.Indices = append(mesh.Indices, i, i+1, i+cells+2, i, i+cells+2, i+cells+1)
+			mesh.Colors = append(mesh.Colors, litColor, litColor)
+		}
+	}
+	return mesh
+}
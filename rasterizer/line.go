@@ -0,0 +1,169 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+package rasterizer
+
+import (
+	"image"
+	"math"
+
+	"github.com/ungerik/go3d/mat4"
+	"github.com/ungerik/go3d/vec3"
+)
+
+type primitive int
+
+const (
+	primTriangle primitive = iota
+	primLine
+	primPoint
+	primBlit
+)
+
+// FillMode selects how triangles are rendered.
+type FillMode int
+
+const (
+	FillSolid FillMode = iota
+	FillWireframe
+)
+
+// WithFillMode sets the fill mode of a draw call. FillWireframe renders the
+// edges of every triangle as lines. Culling still applies.
+func WithFillMode(mode FillMode) DrawOption {
+	return func(dc *drawCall) {
+		dc.fillMode = mode
+	}
+}
+
+// DrawLines queues a list of lines, every two vertices forming a line.
+// colors holds one palette index per line.
+func (r *Rasterizer) DrawLines(mvp *mat4.T, vert []vec3.T, colors []uint8, opts ...DrawOption) uint64 {
+	return r.submit(&drawCall{prim: primLine, mvp: *mvp, vert: vert, colors: colors}, opts)
+}
+
+// DrawPoints queues a list of single pixel points. colors holds one palette
+// index per point.
+func (r *Rasterizer) DrawPoints(mvp *mat4.T, vert []vec3.T, colors []uint8, opts ...DrawOption) uint64 {
+	return r.submit(&drawCall{prim: primPoint, mvp: *mvp, vert: vert, colors: colors}, opts)
+}
+
+// clipLine clips the line a-b against the view frustum. It returns false if
+// the line is entirely outside.
+func clipLine(a, b *clipVertex) (clipVertex, clipVertex, bool) {
+	t0, t1 := float32(0), float32(1)
+
+	for i := range clipPlanes {
+		plane := &clipPlanes[i]
+		da := planeDistance(plane, &a.pos)
+		db := planeDistance(plane, &b.pos)
+
+		switch {
+		case da < 0 && db < 0:
+			return clipVertex{}, clipVertex{}, false
+		case da < 0:
+			if t := da / (da - db); t > t0 {
+				t0 = t
+			}
+		case db < 0:
+			if t := da / (da - db); t < t1 {
+				t1 = t
+			}
+		}
+	}
+
+	if t0 > t1 {
+		return clipVertex{}, clipVertex{}, false
+	}
+
+	var ca, cb clipVertex
+	for k := range ca.pos {
+		d := b.pos[k] - a.pos[k]
+		ca.pos[k] = a.pos[k] + d*t0
+		cb.pos[k] = a.pos[k] + d*t1
+	}
+	lerpVaryings(&ca.varyings, &a.varyings, &b.varyings, t0)
+	lerpVaryings(&cb.varyings, &a.varyings, &b.varyings, t1)
+	return ca, cb, true
+}
+
+func (r *Rasterizer) emitLine(dc *drawCall, color uint8, a, b *clipVertex) {
+	ca, cb, ok := clipLine(a, b)
+	if !ok {
+		return
+	}
+
+	line := triangle{cmd: cmdDraw, id: dc.id, dc: dc, prim: primLine, color: color}
+	project(&ca, dc.viewport, &line.v[0])
+	project(&cb, dc.viewport, &line.v[1])
+	line.v[2] = line.v[1]
+
+	r.emit(line)
+}
+
+func (r *Rasterizer) processLines(dc *drawCall, g *geometryStage) {
+	for i := 0; i+1 < len(dc.vert); i += 2 {
+		r.emitLine(dc, dc.colors[i/2], g.vertex(dc, i), g.vertex(dc, i+1))
+	}
+}
+
+func (r *Rasterizer) processPoints(dc *drawCall, g *geometryStage) {
+	point := triangle{cmd: cmdDraw, id: dc.id, dc: dc, prim: primPoint}
+
+	for i := range dc.vert {
+		cv := g.vertex(dc, i)
+		if outcode(&cv.pos) != 0 {
+			continue
+		}
+
+		point.color = dc.colors[i]
+		project(cv, dc.viewport, &point.v[0])
+		point.v[1] = point.v[0]
+		point.v[2] = point.v[0]
+
+		r.emit(point)
+	}
+}
+
+// rasterizeLine steps along the major axis of the line and shades one pixel
+// per step, including both end points. Every step is visited regardless of
+// clip so the line is identical in all tiles it touches.
+func (r *Rasterizer) rasterizeLine(clip image.Rectangle, tri *triangle) (visited, written int) {
+	a, b := &tri.v[0], &tri.v[1]
+	dx, dy := b.x-a.x, b.y-a.y
+
+	steps := int(math.Ceil(math.Max(math.Abs(float64(dx)), math.Abs(float64(dy)))))
+
+	var attr varyings
+	for i := 0; i <= steps; i++ {
+		var t float32
+		if steps > 0 {
+			t = float32(i) / float32(steps)
+		}
+
+		p := image.Pt(floor(a.x+dx*t), floor(a.y+dy*t))
+		if !p.In(clip) {
+			continue
+		}
+
+		lerpVaryings(&attr, &a.varyings, &b.varyings, t)
+		visited++
+		if r.shadePixel(p.X, p.Y, &attr, 0, tri) {
+			written++
+		}
+	}
+	return
+}
+
+func (r *Rasterizer) rasterizePoint(clip image.Rectangle, tri *triangle) (visited, written int) {
+	v := &tri.v[0]
+	if p := image.Pt(floor(v.x), floor(v.y)); p.In(clip) {
+		visited = 1
+		if r.shadePixel(p.X, p.Y, &v.varyings, 0, tri) {
+			written = 1
+		}
+	}
+	return
+}
@@ -0,0 +1,101 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+package rasterizer
+
+import (
+	"github.com/ungerik/go3d/mat4"
+	"github.com/ungerik/go3d/vec2"
+	"github.com/ungerik/go3d/vec3"
+)
+
+// Topology selects how vertices are assembled into triangles.
+type Topology int
+
+const (
+	// TriangleList uses every three vertices as a separate triangle.
+	TriangleList Topology = iota
+
+	// TriangleStrip forms a triangle from every vertex and the two before
+	// it. Every other triangle is flipped to keep the winding consistent.
+	TriangleStrip
+
+	// TriangleFan forms a triangle from every vertex, the one before it and
+	// the first vertex.
+	TriangleFan
+)
+
+// Mesh describes the geometry of an indexed draw call.
+type Mesh struct {
+	Topology Topology
+	Vertices []vec3.T
+
+	// UVs holds one texture coordinate per vertex, for textured meshes.
+	UVs []vec2.T
+
+	// Normals holds one normal per vertex. Meshes with normals are lit, see
+	// SetLights.
+	Normals []vec3.T
+
+	// Colors holds one palette index per triangle, for flat meshes.
+	Colors []uint8
+
+	// Indices into Vertices. A nil slice draws the vertices in order.
+	Indices []uint16
+}
+
+// DrawMesh queues an indexed mesh. The mesh is flat shaded if texture is
+// nil. Vertices shared by several triangles are only transformed once.
+func (r *Rasterizer) DrawMesh(mvp *mat4.T, mesh *Mesh, texture *Texture, opts ...DrawOption) uint64 {
+	return r.submit(&drawCall{
+		mvp:      *mvp,
+		topology: mesh.Topology,
+		vert:     mesh.Vertices,
+		uvs:      mesh.UVs,
+		colors:   mesh.Colors,
+		indices:  mesh.Indices,
+		normals:  mesh.Normals,
+		texture:  texture,
+	}, opts)
+}
+
+func (dc *drawCall) numTriangles() int {
+	n := len(dc.vert)
+	if dc.indices != nil {
+		n = len(dc.indices)
+	}
+
+	switch dc.topology {
+	case TriangleStrip, TriangleFan:
+		if n < 3 {
+			return 0
+		}
+		return n - 2
+	}
+	return n / 3
+}
+
+// triangleIndices returns the vertex indices of triangle prim.
+func (dc *drawCall) triangleIndices(prim int) (int, int, int) {
+	var a, b, c int
+
+	switch dc.topology {
+	case TriangleStrip:
+		if prim&1 == 0 {
+			a, b, c = prim, prim+1, prim+2
+		} else {
+			a, b, c = prim+1, prim, prim+2
+		}
+	case TriangleFan:
+		a, b, c = 0, prim+1, prim+2
+	default:
+		a, b, c = prim*3, prim*3+1, prim*3+2
+	}
+
+	if dc.indices != nil {
+		return int(dc.indices[a]), int(dc.indices[b]), int(dc.indices[c])
+	}
+	return a, b, c
+}
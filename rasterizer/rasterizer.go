@@ -7,252 +7,118 @@ package rasterizer
 
 import "image"
 
-func (r *Rasterizer) pixelShader(x, y int, u, v float32, texture *image.Paletted) {
-	textureSize := texture.Bounds().Max
-	maxX := textureSize.X - 1
-	maxY := textureSize.Y - 1
+// shadePixel shades pixel x, y of tri and reports if it was written.
+func (r *Rasterizer) shadePixel(x, y int, attr *varyings, lod float32, tri *triangle) bool {
+	dc := tri.dc
+	target := dc.target.Image
 
-	tx := int(u * float32(maxX))
-	ty := int(v * float32(maxY))
+	i := target.PixOffset(x, y)
+	z := attr[varyingZ]
 
-	if tx > maxX {
-		tx = maxX
-	} else if tx < 0 {
-		tx = 0
+	if !depthTest(i, z, dc) {
+		return false
 	}
 
-	if ty > maxY {
-		ty = maxY
-	} else if ty < 0 {
-		ty = 0
+	in := Attributes{
+		Z:         z,
+		U:         attr[varyingU],
+		V:         attr[varyingV],
+		LOD:       lod,
+		Light:     attr[varyingLight],
+		Intensity: attr[varyingIntensity],
+		Color:     tri.color,
 	}
 
-	r.target.SetColorIndex(x, y, texture.ColorIndexAt(tx, ty))
-}
-
-func (r *Rasterizer) rasterizeTextured(x0, y0, x1, y1, x2, y2 int, u0, v0, u1, v1, u2, v2 float32, texture *image.Paletted) {
-	// Declare some variables that we'll use and where starting from y0 at the
-	// top of the triangle
-	dxdy1 := float32(x2 - x0)
-	dxdu1 := u2 - u0
-	dxdv1 := v2 - v0
-
-	dxdy2 := float32(x1 - x0)
-	dxdu2 := u1 - v0
-	dxdv2 := v1 - v0
-
-	var (
-		sdx, sdu, sdv,
-		edx, edu, edv,
-		pu, pv float32
-	)
-
-	dy1 := float32(y2 - y0)
-	dy2 := float32(y1 - y0)
-
-	// Check for divide by zero's
-	if y2-y0 != 0 {
-		dxdy1 /= dy1
-		dxdu1 /= dy1
-		dxdv1 /= dy1
-	}
-
-	if y1-y0 != 0 {
-		dxdy2 /= dy2
-		dxdu2 /= dy2
-		dxdv2 /= dy2
-	}
-
-	var (
-		dxldy, dxrdy,
-		dxldu, dxrdu,
-		dxldv, dxrdv float32
-	)
-
-	// Determine our left and right points for our x value gradient..
-	// e.g. the starting and ending line for our x inner loop
-	if dxdy1 < dxdy2 {
-		dxldy = dxdy1
-		dxrdy = dxdy2
-		dxldu = dxdu1
-		dxrdu = dxdu2
-		dxldv = dxdv1
-		dxrdv = dxdv2
-	} else {
-		dxldy = dxdy2
-		dxrdy = dxdy1
-		dxldu = dxdu2
-		dxrdu = dxdu1
-		dxldv = dxdv2
-		dxrdv = dxdv1
-	}
-
-	// Initial starting x and ending x is sdx and edx which are x0,y0...the
-	// top of our triangle
-	sdx = float32(x0)
-	sdu = u0
-	sdv = v0
-
-	edx = float32(x0)
-	edu = u0
-	edv = v0
-
-	var (
-		pDeltaU,
-		pDeltaV float32
-	)
-
-	for y := y0; y <= y2; y++ {
-		pDeltaU = edu - sdu
-		pDeltaV = edv - sdv
-
-		if edx-sdx != 0 {
-			pDeltaU /= edx - sdx
-			pDeltaV /= edx - sdx
-		}
-
-		pu = sdu
-		pv = sdv
-
-		for x := int(sdx); x <= int(edx); x++ {
-			pixelShader(x, y, pu, pv, texture)
-			pu += pDeltaU
-			pv += pDeltaV
-		}
-
-		sdx += dxldy
-		sdu += dxldu
-		sdv += dxldv
-		edx += dxrdy
-		edu += dxrdu
-		edv += dxrdv
-	}
-
-	// Render bottom part of triangle.
-
-	if dxdy1 < dxdy2 {
-		dxldy = float32(x1 - x2)
-		dxldu = u1 - u2
-		dxldv = v1 - v2
-
-		if y1-y2 != 0 {
-			dxldy /= float32(y1 - y2)
-			dxldu /= float32(y1 - y2)
-			dxldv /= float32(y1 - y2)
+	if index, ok := dc.shader.Shade(x, y, &in, &dc.uniforms); ok {
+		index += tri.offset
+		if dc.shadeTable != nil {
+			index = dc.shadeTable.Shade(index, in.Light)
 		}
-
-		sdx = float32(x2)
-		sdu = u2
-		sdv = v2
-	} else {
-		dxrdy = float32(x1 - x2)
-		dxrdu = u1 - u2
-		dxrdv = v1 - v2
-
-		if y1-y2 != 0 {
-			dxrdy /= float32(y1 - y2)
-			dxrdu /= float32(y1 - y2)
-			dxrdv /= float32(y1 - y2)
+		if dc.fogTable != nil {
+			index = dc.fog.apply(dc.fogTable, index, attr[varyingW])
 		}
-
-		edx = float32(x2)
-		edu = u2
-		edv = v2
-	}
-
-	for y := y2; y <= y1; y++ {
-		pDeltaU = edu - sdu
-		pDeltaV = edv - sdv
-
-		if edx-sdx != 0 {
-			pDeltaU /= edx - sdx
-			pDeltaV /= edx - sdx
+		if dc.blend != nil {
+			index = dc.blend.Blend(index, target.Pix[i])
 		}
 
-		pu = sdu
-		pv = sdv
-
-		for x := int(sdx); x <= int(edx); x++ {
-			pixelShader(x, y, pu, pv, texture)
-			pu += pDeltaU
-			pv += pDeltaV
+		if dc.debugMode == DebugOverdraw {
+			index = dc.target.heat.count(i, dc.heat)
 		}
 
-		sdx += dxldy
-		sdu += dxldu
-		sdv += dxldv
-		edx += dxrdy
-		edu += dxrdu
-		edv += dxrdv
+		target.Pix[i] = index
+		depthWrite(i, z, dc)
+		return true
 	}
+	return false
 }
 
-func (r *Rasterizer) rasterizeFlat(x0, y0, x1, y1, x2, y2 int, color uint8) {
-	dxdy1 := float32(x2 - x0)
-	dxdy2 := float32(x1 - x0)
+// edge evaluates the x coordinate and the varyings of the edge a-b at row y.
+func edge(a, b *vertex, y int, x *float32, attr *varyings) {
+	dy := float32(int(b.y) - int(a.y))
+	if dy == 0 {
+		*x = float32(int(a.x))
+		*attr = a.varyings
+		return
+	}
 
-	var (
-		sdx, edx float32
-	)
+	t := float32(y-int(a.y)) / dy
+	*x = float32(int(a.x)) + float32(int(b.x)-int(a.x))*t
+	lerpVaryings(attr, &a.varyings, &b.varyings, t)
+}
 
-	dy1 := float32(y2 - y0)
-	dy2 := float32(y1 - y0)
+// rasterizeScanline is the classic scanline rasterizer. Vertices are
+// truncated to integer coordinates and spans include both end points. All
+// values are evaluated from the row and column offsets rather than
+// accumulated, so clipping the triangle to a tile yields exactly the same
+// pixels as rasterizing it in one go.
+func (r *Rasterizer) rasterizeScanline(clip image.Rectangle, tri *triangle) (visited, written int) {
+	top, mid, bottom := &tri.v[0], &tri.v[1], &tri.v[2]
 
-	// Check for divide by zero's
-	if y2-y0 != 0 {
-		dxdy1 /= dy1
+	if int(mid.y) < int(top.y) {
+		top, mid = mid, top
 	}
-
-	if y1-y0 != 0 {
-		dxdy2 /= dy2
+	if int(bottom.y) < int(top.y) {
+		top, bottom = bottom, top
+	}
+	if int(bottom.y) < int(mid.y) {
+		mid, bottom = bottom, mid
 	}
 
 	var (
-		dxldy, dxrdy float32
+		sdx, edx float32
+		sda, eda varyings
 	)
 
-	if dxdy1 < dxdy2 {
-		dxldy = dxdy1
-		dxrdy = dxdy2
-	} else {
-		dxldy = dxdy2
-		dxrdy = dxdy1
-	}
+	for y := maxInt(int(top.y), clip.Min.Y); y <= minInt(int(bottom.y), clip.Max.Y-1); y++ {
+		edge(top, bottom, y, &sdx, &sda)
 
-	sdx = float32(x0)
-	edx = float32(x0)
+		if y < int(mid.y) {
+			edge(top, mid, y, &edx, &eda)
+		} else {
+			edge(mid, bottom, y, &edx, &eda)
+		}
 
-	for y := y0; y <= y2; y++ {
-		for x := int(sdx); x <= int(edx); x++ {
-			r.target.SetColorIndex(x, y, color)
+		if edx < sdx {
+			sdx, edx = edx, sdx
+			sda, eda = eda, sda
 		}
 
-		sdx += dxldy
-		edx += dxrdy
+		v, w := r.drawSpan(clip, y, sdx, edx, &sda, &eda, tri)
+		visited, written = visited+v, written+w
 	}
+	return
+}
 
-	// Render bottom part of triangle.
-
-	if dxdy1 < dxdy2 {
-		dxldy = float32(x1 - x2)
-		if y1-y2 != 0 {
-			dxldy /= float32(y1 - y2)
-		}
-		sdx = float32(x2)
-	} else {
-		dxrdy = float32(x1 - x2)
-		if y1-y2 != 0 {
-			dxrdy /= float32(y1 - y2)
-		}
-		edx = float32(x2)
+func minInt(a, b int) int {
+	if a < b {
+		return a
 	}
+	return b
+}
 
-	for y := y2; y <= y1; y++ {
-		for x := int(sdx); x <= int(edx); x++ {
-			r.target.SetColorIndex(x, y, color)
-		}
-
-		sdx += dxldy
-		edx += dxrdy
+func maxInt(a, b int) int {
+	if a > b {
+		return a
 	}
+	return b
 }
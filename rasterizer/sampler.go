@@ -0,0 +1,96 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+package rasterizer
+
+import "image"
+
+// AddressMode selects how texture coordinates outside the range 0 to 1 are
+// resolved.
+type AddressMode int
+
+const (
+	AddressClamp AddressMode = iota
+	AddressRepeat
+	AddressMirror
+)
+
+// Sampler describes how texels are fetched from a texture.
+type Sampler struct {
+	AddressU, AddressV AddressMode
+
+	// ColorKey enables discarding of texels with the palette index
+	// Transparent.
+	ColorKey    bool
+	Transparent uint8
+}
+
+// WithSampler sets the sampler of a textured draw call. The default clamps
+// both axes and has no color key.
+func WithSampler(sampler Sampler) DrawOption {
+	return func(dc *drawCall) {
+		dc.uniforms.Sampler = sampler
+	}
+}
+
+func isPowerOfTwo(n int) bool {
+	return n > 0 && n&(n-1) == 0
+}
+
+func address(mode AddressMode, t, size int) int {
+	switch mode {
+	case AddressRepeat:
+		if isPowerOfTwo(size) {
+			return t & (size - 1)
+		}
+		if t %= size; t < 0 {
+			t += size
+		}
+		return t
+	case AddressMirror:
+		period := size * 2
+		if isPowerOfTwo(size) {
+			t &= period - 1
+		} else if t %= period; t < 0 {
+			t += period
+		}
+		if t >= size {
+			t = period - 1 - t
+		}
+		return t
+	}
+
+	if t < 0 {
+		return 0
+	} else if t >= size {
+		return size - 1
+	}
+	return t
+}
+
+// floor is faster than math.Floor for the value range of texels.
+func floor(v float32) int {
+	i := int(v)
+	if v < float32(i) {
+		i--
+	}
+	return i
+}
+
+// Sample point samples texture at u, v. It returns false if the texel
+// matches the color key.
+func (s *Sampler) Sample(texture *image.Paletted, u, v float32) (uint8, bool) {
+	rect := texture.Rect
+	w, h := rect.Dx(), rect.Dy()
+
+	tx := address(s.AddressU, floor(u*float32(w)), w)
+	ty := address(s.AddressV, floor(v*float32(h)), h)
+
+	index := texture.Pix[ty*texture.Stride+tx]
+	if s.ColorKey && index == s.Transparent {
+		return index, false
+	}
+	return index, true
+}
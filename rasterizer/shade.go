@@ -0,0 +1,120 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+package rasterizer
+
+import (
+	"image/color"
+	"math"
+)
+
+// ShadeTable maps every palette index to the closest palette color of a
+// darkened or brightened version of itself, for a number of light levels.
+// It is the paletted equivalent of multiplying a color by a light
+// intensity, much like the COLORMAP lump in Doom.
+type ShadeTable struct {
+	levels    int
+	brightest float32
+	table     []uint8
+}
+
+// NewShadeTable builds a table with levels light levels, spread evenly from
+// black at light 0 to the palette scaled by brightest. A brightest of 1
+// only darkens, higher values also brighten towards white.
+func NewShadeTable(pal color.Palette, levels int, brightest float32) *ShadeTable {
+	if levels < 2 {
+		levels = 2
+	}
+
+	t := &ShadeTable{
+		levels:    levels,
+		brightest: brightest,
+		table:     make([]uint8, levels*256),
+	}
+
+	for level := 0; level < levels; level++ {
+		scale := brightest * float32(level) / float32(levels-1)
+		row := t.table[level*256 : (level+1)*256]
+
+		for i, c := range pal {
+			r, g, b, _ := c.RGBA()
+			row[i] = nearestIndex(pal, scaleChannel(r, scale), scaleChannel(g, scale), scaleChannel(b, scale))
+		}
+	}
+	return t
+}
+
+// Levels returns the number of light levels in the table.
+func (t *ShadeTable) Levels() int {
+	return t.levels
+}
+
+// Level returns the table level closest to light.
+func (t *ShadeTable) Level(light float32) int {
+	level := int(light/t.brightest*float32(t.levels-1) + 0.5)
+	if level < 0 {
+		return 0
+	} else if level >= t.levels {
+		return t.levels - 1
+	}
+	return level
+}
+
+// Shade returns index lit by light, where 1 leaves the color unchanged.
+func (t *ShadeTable) Shade(index uint8, light float32) uint8 {
+	return t.table[t.Level(light)*256+int(index)]
+}
+
+// WithShadeTable makes a draw call resolve the light level of every
+// fragment through table after the pixel shader has run.
+func WithShadeTable(table *ShadeTable) DrawOption {
+	return func(dc *drawCall) {
+		dc.shadeTable = table
+	}
+}
+
+// WithLight sets the light level of a whole draw call. The default is 1.
+func WithLight(light float32) DrawOption {
+	return func(dc *drawCall) {
+		dc.light = light
+	}
+}
+
+// WithVertexLight sets one light level per vertex. They are interpolated
+// across the triangles and override WithLight.
+func WithVertexLight(lights []float32) DrawOption {
+	return func(dc *drawCall) {
+		dc.lights = lights
+	}
+}
+
+func scaleChannel(c uint32, scale float32) uint8 {
+	v := float32(c>>8) * scale
+	if v > 255 {
+		return 255
+	}
+	return uint8(v + 0.5)
+}
+
+// nearestIndex returns the palette index closest to r, g, b. Unlike
+// color.Palette.Index it avoids the allocation of a color.Color.
+func nearestIndex(pal color.Palette, r, g, b uint8) uint8 {
+	best, bestDist := 0, math.MaxInt32
+
+	for i, c := range pal {
+		cr, cg, cb, _ := c.RGBA()
+		dr := int(cr>>8) - int(r)
+		dg := int(cg>>8) - int(g)
+		db := int(cb>>8) - int(b)
+
+		if dist := dr*dr + dg*dg + db*db; dist < bestDist {
+			best, bestDist = i, dist
+			if dist == 0 {
+				break
+			}
+		}
+	}
+	return uint8(best)
+}
@@ -0,0 +1,96 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+package rasterizer
+
+// Attributes are the values a pixel shader receives for a fragment.
+type Attributes struct {
+	// Z is the depth of the fragment in the range 0 to 1.
+	Z float32
+
+	// U and V are the texture coordinates of the fragment. They are
+	// already perspective corrected if the draw call asked for it.
+	U, V float32
+
+	// LOD is the mip level of detail of the fragment, 0 is full size.
+	LOD float32
+
+	// Light is the interpolated light level, see WithLight.
+	Light float32
+
+	// Intensity is the interpolated Gouraud intensity, see WithGouraud.
+	Intensity float32
+
+	// Color is the palette index given to the triangle in DrawFlat.
+	Color uint8
+}
+
+// Uniforms are the values shared by all fragments of a draw call.
+type Uniforms struct {
+	Texture *Texture
+	Sampler Sampler
+
+	// Ramp and Dither are set by WithGouraud and WithDither.
+	Ramp   []uint8
+	Dither bool
+
+	// Data is whatever was passed to WithUniforms.
+	Data interface{}
+}
+
+// PixelShader computes the palette index of a fragment. Returning false
+// discards the fragment, leaving both the target and the depth buffer
+// untouched. Shaders are called concurrently from several goroutines.
+type PixelShader interface {
+	Shade(x, y int, in *Attributes, uniforms *Uniforms) (uint8, bool)
+}
+
+// The PixelShaderFunc type is an adapter to allow the use of ordinary
+// functions as pixel shaders.
+type PixelShaderFunc func(x, y int, in *Attributes, uniforms *Uniforms) (uint8, bool)
+
+func (f PixelShaderFunc) Shade(x, y int, in *Attributes, uniforms *Uniforms) (uint8, bool) {
+	return f(x, y, in, uniforms)
+}
+
+type flatShader struct{}
+
+func (flatShader) Shade(x, y int, in *Attributes, uniforms *Uniforms) (uint8, bool) {
+	return in.Color, true
+}
+
+type texturedShader struct{}
+
+func (texturedShader) Shade(x, y int, in *Attributes, uniforms *Uniforms) (uint8, bool) {
+	return uniforms.Texture.Sample(&uniforms.Sampler, in.U, in.V, in.LOD)
+}
+
+// NewDefaultFlatShader returns the shader used by DrawFlat. It writes the
+// triangle color.
+func NewDefaultFlatShader() PixelShader {
+	return flatShader{}
+}
+
+// NewDefaultTexturedShader returns the shader used by DrawTextured. It
+// point samples the closest mip level of the texture with the sampler of the
+// draw call.
+func NewDefaultTexturedShader() PixelShader {
+	return texturedShader{}
+}
+
+// WithPixelShader replaces the default shader of a draw call.
+func WithPixelShader(shader PixelShader) DrawOption {
+	return func(dc *drawCall) {
+		dc.shader = shader
+	}
+}
+
+// WithUniforms attaches data to a draw call that is handed to its pixel
+// shader in Uniforms.Data.
+func WithUniforms(data interface{}) DrawOption {
+	return func(dc *drawCall) {
+		dc.uniforms.Data = data
+	}
+}
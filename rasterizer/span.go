@@ -0,0 +1,147 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+package rasterizer
+
+import "image"
+
+// Length in pixels of the spans that MappingSubdivided interpolates
+// linearly between perspective correct end points.
+const subdivisionSpan = 16
+
+// TextureMapping selects how texture coordinates are interpolated across a
+// triangle.
+type TextureMapping int
+
+const (
+	// MappingAffine interpolates u and v linearly in screen space. It is
+	// the cheapest mode and exact for geometry parallel to the screen, such
+	// as 2D and UI elements.
+	MappingAffine TextureMapping = iota
+
+	// MappingPerspective interpolates u/w, v/w and 1/w and divides them
+	// per pixel.
+	MappingPerspective
+
+	// MappingSubdivided is perspective correct every 16 pixels and affine
+	// in between.
+	MappingSubdivided
+)
+
+// WithTextureMapping sets the texture mapping mode of a draw call. The
+// default is MappingAffine.
+func WithTextureMapping(mapping TextureMapping) DrawOption {
+	return func(dc *drawCall) {
+		dc.mapping = mapping
+	}
+}
+
+func lerpVaryings(attr, a, b *varyings, t float32) {
+	for i := range attr {
+		attr[i] = a[i] + (b[i]-a[i])*t
+	}
+}
+
+// perspectiveUV returns the texture coordinates at pixel x of the span
+// starting at sdx.
+func perspectiveUV(sdx, scale float32, sda, eda *varyings, x int) (float32, float32) {
+	var attr varyings
+	lerpVaryings(&attr, sda, eda, float32(x-int(sdx))*scale)
+
+	w := 1 / attr[varyingW]
+	return attr[varyingU] * w, attr[varyingV] * w
+}
+
+// drawSpan shades the pixels of row y from sdx to edx, inclusive, that fall
+// inside clip and are not hidden by the span buffer, and returns how many
+// there were and how many of them were written. Interpolation is anchored
+// to the unclipped span so the result does not depend on clip.
+func (r *Rasterizer) drawSpan(clip image.Rectangle, y int, sdx, edx float32, sda, eda *varyings, tri *triangle) (visited, written int) {
+	var (
+		scale float32
+		attr  varyings
+
+		segStart, segEnd           int
+		segU0, segV0, segU1, segV1 float32
+	)
+
+	if edx-sdx != 0 {
+		scale = 1 / (edx - sdx)
+	}
+
+	x0, x1 := int(sdx), int(edx)
+	mapping := tri.dc.mapping
+	segEnd = x0 - 1
+
+	start, end := maxInt(x0, clip.Min.X), minInt(x1, clip.Max.X-1)
+	if start > end {
+		return 0, 0
+	}
+
+	var lod float32
+	if tri.dc.texture != nil && tri.dc.texture.Levels() > 1 {
+		lod = spanLOD(tri, sda, eda)
+	}
+
+	var buf [tileSize/2 + 1]span
+	spans := tri.dc.target.spans
+	gaps := append(buf[:0], span{start, end})
+	if tri.dc.occluder {
+		gaps = spans.gaps(y, start, end, buf[:0])
+	}
+
+	for _, gap := range gaps {
+		visited += gap.x1 - gap.x0 + 1
+
+		// Start of the current run of written pixels, covered in the span
+		// buffer once it ends.
+		run := -1
+
+		for x := gap.x0; x <= gap.x1; x++ {
+			lerpVaryings(&attr, sda, eda, float32(x-x0)*scale)
+
+			if tri.dc.texture != nil {
+				switch mapping {
+				case MappingPerspective:
+					w := 1 / attr[varyingW]
+					attr[varyingU] *= w
+					attr[varyingV] *= w
+				case MappingSubdivided:
+					if x >= segEnd {
+						segStart = x0 + (x-x0)/subdivisionSpan*subdivisionSpan
+						segEnd = minInt(segStart+subdivisionSpan, x1)
+						segU0, segV0 = perspectiveUV(sdx, scale, sda, eda, segStart)
+						segU1, segV1 = perspectiveUV(sdx, scale, sda, eda, segEnd)
+					}
+
+					var t float32
+					if segEnd != segStart {
+						t = float32(x-segStart) / float32(segEnd-segStart)
+					}
+
+					attr[varyingU] = segU0 + (segU1-segU0)*t
+					attr[varyingV] = segV0 + (segV1-segV0)*t
+				}
+			}
+
+			if r.shadePixel(x, y, &attr, lod, tri) {
+				written++
+				if run < 0 {
+					run = x
+				}
+			} else if run >= 0 {
+				if tri.dc.occluder {
+					spans.cover(y, run, x-1)
+				}
+				run = -1
+			}
+		}
+
+		if run >= 0 && tri.dc.occluder {
+			spans.cover(y, run, gap.x1)
+		}
+	}
+	return
+}
@@ -0,0 +1,158 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+package rasterizer
+
+import (
+	"image"
+	"sort"
+)
+
+// ConfigWithSpanBuffer enables span buffer hidden surface removal. Every
+// render target keeps a list of covered spans per scanline and opaque
+// triangles only shade the parts of their spans that are not yet covered,
+// so each pixel is shaded at most once. The triangles of a draw call are
+// sorted front to back, but draw calls are not reordered and should be
+// submitted front to back as well. ClearDepth resets the coverage.
+//
+// Triangles are opaque unless they are blended, use a color keyed sampler,
+// a custom pixel shader or disable depth writes. Other triangles and
+// primitives ignore the span buffer and should be drawn after the opaque
+// geometry. Only pixels that pass the depth test are covered.
+func ConfigWithSpanBuffer(r *Rasterizer) error {
+	r.spanBuffer = true
+	return nil
+}
+
+// span is an inclusive range of covered pixels.
+type span struct {
+	x0, x1 int
+}
+
+// spanBuffer holds the covered spans of a render target. Rows are split at
+// tile boundaries so tiles rasterized in parallel never share a span list.
+type spanBuffer struct {
+	bounds  image.Rectangle
+	columns int
+	rows    [][]span
+
+	// Pixels rejected per tile since the last flush.
+	hidden []int
+}
+
+func newSpanBuffer(bounds image.Rectangle) *spanBuffer {
+	columns := (bounds.Dx() + tileSize - 1) / tileSize
+	rows := (bounds.Dy() + tileSize - 1) / tileSize
+
+	return &spanBuffer{
+		bounds:  bounds,
+		columns: columns,
+		rows:    make([][]span, bounds.Dy()*columns),
+		hidden:  make([]int, rows*columns),
+	}
+}
+
+func (b *spanBuffer) clear() {
+	for i := range b.rows {
+		b.rows[i] = b.rows[i][:0]
+	}
+}
+
+// gaps appends the parts of the pixels x0 to x1 of row y that are not
+// covered to gaps and counts the rest as hidden. The range must not cross a
+// tile boundary.
+func (b *spanBuffer) gaps(y, x0, x1 int, gaps []span) []span {
+	column := (x0 - b.bounds.Min.X) / tileSize
+	row := y - b.bounds.Min.Y
+	spans := b.rows[row*b.columns+column]
+
+	i := sort.Search(len(spans), func(i int) bool { return spans[i].x1 >= x0 })
+
+	x, hidden := x0, 0
+	for ; i < len(spans) && spans[i].x0 <= x1; i++ {
+		s := spans[i]
+		if s.x0 > x {
+			gaps = append(gaps, span{x, s.x0 - 1})
+		}
+		hidden += minInt(s.x1, x1) - maxInt(s.x0, x) + 1
+		x = s.x1 + 1
+	}
+	if x <= x1 {
+		gaps = append(gaps, span{x, x1})
+	}
+
+	b.hidden[(row/tileSize)*b.columns+column] += hidden
+	return gaps
+}
+
+// cover marks the pixels x0 to x1 of row y as covered. The range must not
+// cross a tile boundary.
+func (b *spanBuffer) cover(y, x0, x1 int) {
+	column := (x0 - b.bounds.Min.X) / tileSize
+	row := y - b.bounds.Min.Y
+	spans := b.rows[row*b.columns+column]
+
+	// Index of the first span that ends at or after x0 - 1, so a span that
+	// merely touches the new one is merged as well.
+	first := sort.Search(len(spans), func(i int) bool { return spans[i].x1 >= x0-1 })
+
+	last := first
+	for last < len(spans) && spans[last].x0 <= x1+1 {
+		last++
+	}
+
+	merged := span{x0, x1}
+	if first < last {
+		merged.x0 = minInt(merged.x0, spans[first].x0)
+		merged.x1 = maxInt(merged.x1, spans[last-1].x1)
+	}
+
+	// Replace the spans first to last with the merged one.
+	if first == last {
+		spans = append(spans, span{})
+		copy(spans[first+1:], spans[first:])
+	} else {
+		spans = append(spans[:first+1], spans[last:]...)
+	}
+	spans[first] = merged
+
+	b.rows[row*b.columns+column] = spans
+}
+
+// takeHidden returns and resets the number of rejected pixels.
+func (b *spanBuffer) takeHidden() (n int) {
+	for i, h := range b.hidden {
+		n += h
+		b.hidden[i] = 0
+	}
+	return
+}
+
+// opaque reports if the triangles of dc can occlude other triangles.
+func (dc *drawCall) opaque() bool {
+	if dc.prim != primTriangle || dc.fillMode != FillSolid || dc.blendMode != BlendNone || dc.blend != nil || dc.uniforms.Sampler.ColorKey {
+		return false
+	}
+	if dc.target.depth != nil && !dc.depthWrite {
+		return false
+	}
+
+	switch dc.shader.(type) {
+	case flatShader, texturedShader, gouraudShader:
+		return true
+	}
+	return false
+}
+
+// sortFrontToBack orders triangles by the average depth of their vertices.
+func sortFrontToBack(triangles []triangle) {
+	depth := func(t *triangle) float32 {
+		return t.v[0].varyings[varyingZ] + t.v[1].varyings[varyingZ] + t.v[2].varyings[varyingZ]
+	}
+
+	sort.SliceStable(triangles, func(i, j int) bool {
+		return depth(&triangles[i]) < depth(&triangles[j])
+	})
+}
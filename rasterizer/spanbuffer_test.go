@@ -0,0 +1,80 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+package rasterizer_test
+
+import (
+	"image"
+	"math/rand"
+	"testing"
+
+	"github.com/andreas-jonsson/drive/rasterizer"
+	"github.com/ungerik/go3d/mat4"
+	"github.com/ungerik/go3d/vec3"
+)
+
+// TestSpanBuffer checks that overlapping triangles at different depths,
+// submitted in random order, resolve to the same image with a span buffer
+// as with a depth buffer.
+func TestSpanBuffer(t *testing.T) {
+	vert, colors := randomTriangles()
+
+	scene := func(r *rasterizer.Rasterizer) {
+		r.DrawFlat(&mat4.Ident, vert, colors)
+	}
+
+	for _, mode := range rasterizerModes {
+		depth := render(t, append(mode.configs, rasterizer.ConfigWithDepthBuffer), scene)
+		spans := render(t, append(mode.configs, rasterizer.ConfigWithSpanBuffer), scene)
+		comparePixels(t, mode.name, spans, depth)
+	}
+}
+
+// TestSpanBufferDepth checks that pixels rejected by the depth test do not
+// hide the triangles drawn after them. The quad is behind all triangles.
+func TestSpanBufferDepth(t *testing.T) {
+	vert, colors := randomTriangles()
+	quad := []vec3.T{{-1, -1, 0.95}, {1, -1, 0.95}, {1, 1, 0.95}, {-1, -1, 0.95}, {1, 1, 0.95}, {-1, 1, 0.95}}
+
+	scene := func(r *rasterizer.Rasterizer) {
+		r.DrawFlat(&mat4.Ident, quad, []uint8{1, 1}, rasterizer.WithDepthFunc(rasterizer.DepthNever))
+		r.DrawFlat(&mat4.Ident, vert, colors)
+		r.DrawFlat(&mat4.Ident, quad, []uint8{2, 2})
+	}
+
+	for _, mode := range rasterizerModes {
+		depth := render(t, append(mode.configs, rasterizer.ConfigWithDepthBuffer), scene)
+		spans := render(t, append(mode.configs, rasterizer.ConfigWithDepthBuffer, rasterizer.ConfigWithSpanBuffer), scene)
+		comparePixels(t, mode.name, spans, depth)
+	}
+}
+
+// randomTriangles returns overlapping triangles at different depths in
+// random order, with one color per triangle.
+func randomTriangles() ([]vec3.T, []uint8) {
+	rnd := rand.New(rand.NewSource(1))
+
+	var (
+		vert   []vec3.T
+		colors []uint8
+	)
+
+	for i := 0; i < 100; i++ {
+		x, y, z := rnd.Float32()*2-1, rnd.Float32()*2-1, rnd.Float32()*0.9
+		s := rnd.Float32() * 0.8
+		vert = append(vert, vec3.T{x, y, z}, vec3.T{x + s, y, z}, vec3.T{x, y + s, z})
+		colors = append(colors, uint8(1+rnd.Intn(255)))
+	}
+	return vert, colors
+}
+
+func comparePixels(t *testing.T, name string, img, want *image.Paletted) {
+	for i := range want.Pix {
+		if img.Pix[i] != want.Pix[i] {
+			x, y := i%goldenWidth, i/goldenWidth
+			t.Errorf("%s: pixel %d,%d is %d, want %d", name, x, y, img.Pix[i], want.Pix[i])
+		}
+	}
+}
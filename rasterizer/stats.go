@@ -0,0 +1,130 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+package rasterizer
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats holds counters collected by the rasterizer.
+type Stats struct {
+	DrawCalls uint64
+
+	// TrianglesSubmitted is the number of triangles in the processed draw
+	// calls. TrianglesClipped counts the ones that crossed a frustum plane,
+	// including those entirely outside of it.
+	TrianglesSubmitted uint64
+	TrianglesCulled    uint64
+	TrianglesClipped   uint64
+
+	// Primitives is the number of triangles, lines, points and blits that
+	// reached the raster stage after clipping.
+	Primitives uint64
+
+	// Pixels is the number of pixels visited by the raster stage and
+	// PixelsWritten the number of them that passed the depth test and the
+	// pixel shader.
+	Pixels        uint64
+	PixelsWritten uint64
+
+	// PixelsHidden is the number of pixels the span buffer rejected, i.e.
+	// the overdraw it saved.
+	PixelsHidden uint64
+
+	// GeometryTime is the time spent transforming and clipping draw calls,
+	// including TriangleQueueStall.
+	GeometryTime time.Duration
+
+	// BinTime is the time spent sorting triangles into tiles.
+	BinTime time.Duration
+
+	// RasterTime is the time spent rasterizing tiles, summed over all
+	// workers. It can exceed the wall clock time on multicore machines.
+	RasterTime time.Duration
+
+	// DrawCallQueueStall and TriangleQueueStall are the time spent blocked
+	// on a full draw call or triangle queue, i.e. waiting for the next
+	// stage of the pipeline to catch up.
+	DrawCallQueueStall time.Duration
+	TriangleQueueStall time.Duration
+}
+
+// Stats returns a snapshot of the counters accumulated since the
+// rasterizer was created.
+func (r *Rasterizer) Stats() Stats {
+	s := &r.stats
+	return Stats{
+		DrawCalls:          atomic.LoadUint64(&s.DrawCalls),
+		TrianglesSubmitted: atomic.LoadUint64(&s.TrianglesSubmitted),
+		TrianglesCulled:    atomic.LoadUint64(&s.TrianglesCulled),
+		TrianglesClipped:   atomic.LoadUint64(&s.TrianglesClipped),
+		Primitives:         atomic.LoadUint64(&s.Primitives),
+		Pixels:             atomic.LoadUint64(&s.Pixels),
+		PixelsWritten:      atomic.LoadUint64(&s.PixelsWritten),
+		PixelsHidden:       atomic.LoadUint64(&s.PixelsHidden),
+		GeometryTime:       loadDuration(&s.GeometryTime),
+		BinTime:            loadDuration(&s.BinTime),
+		RasterTime:         loadDuration(&s.RasterTime),
+		DrawCallQueueStall: loadDuration(&s.DrawCallQueueStall),
+		TriangleQueueStall: loadDuration(&s.TriangleQueueStall),
+	}
+}
+
+// FrameStats waits for everything submitted so far to be rasterized and
+// returns the counters accumulated since the previous call to FrameStats.
+// Call it once at the end of every frame.
+func (r *Rasterizer) FrameStats() Stats {
+	r.Sync()
+	s := r.Stats()
+
+	r.submitMutex.Lock()
+	frame := s.Sub(r.frameStats)
+	r.frameStats = s
+	r.submitMutex.Unlock()
+
+	return frame
+}
+
+// Sub returns the difference between s and an earlier snapshot o.
+func (s Stats) Sub(o Stats) Stats {
+	return Stats{
+		DrawCalls:          s.DrawCalls - o.DrawCalls,
+		TrianglesSubmitted: s.TrianglesSubmitted - o.TrianglesSubmitted,
+		TrianglesCulled:    s.TrianglesCulled - o.TrianglesCulled,
+		TrianglesClipped:   s.TrianglesClipped - o.TrianglesClipped,
+		Primitives:         s.Primitives - o.Primitives,
+		Pixels:             s.Pixels - o.Pixels,
+		PixelsWritten:      s.PixelsWritten - o.PixelsWritten,
+		PixelsHidden:       s.PixelsHidden - o.PixelsHidden,
+		GeometryTime:       s.GeometryTime - o.GeometryTime,
+		BinTime:            s.BinTime - o.BinTime,
+		RasterTime:         s.RasterTime - o.RasterTime,
+		DrawCallQueueStall: s.DrawCallQueueStall - o.DrawCallQueueStall,
+		TriangleQueueStall: s.TriangleQueueStall - o.TriangleQueueStall,
+	}
+}
+
+func loadDuration(d *time.Duration) time.Duration {
+	return time.Duration(atomic.LoadInt64((*int64)(d)))
+}
+
+func addDuration(d *time.Duration, v time.Duration) {
+	atomic.AddInt64((*int64)(d), int64(v))
+}
+
+func (s *Stats) addGeometry(submitted, clipped int, d time.Duration) {
+	atomic.AddUint64(&s.DrawCalls, 1)
+	atomic.AddUint64(&s.TrianglesSubmitted, uint64(submitted))
+	atomic.AddUint64(&s.TrianglesClipped, uint64(clipped))
+	addDuration(&s.GeometryTime, d)
+}
+
+func (s *Stats) addRaster(visited, written int, d time.Duration) {
+	atomic.AddUint64(&s.Pixels, uint64(visited))
+	atomic.AddUint64(&s.PixelsWritten, uint64(written))
+	addDuration(&s.RasterTime, d)
+}
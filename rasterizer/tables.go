@@ -0,0 +1,130 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+package rasterizer
+
+import "image/color"
+
+// Tables are only kept for the most recently used palettes and fog colors,
+// so palette fades and cycling do not grow the cache without bound.
+const (
+	maxPaletteTables = 4
+	maxFogTables     = 8
+)
+
+// paletteID identifies a palette by the slice that holds it. A palette is
+// changed by replacing it, tables built from a palette that is edited in
+// place are not rebuilt.
+type paletteID struct {
+	first *color.Color
+	n     int
+}
+
+func idOf(pal color.Palette) paletteID {
+	if len(pal) == 0 {
+		return paletteID{}
+	}
+	return paletteID{&pal[0], len(pal)}
+}
+
+// paletteTables holds the tables built from one palette.
+type paletteTables struct {
+	id      paletteID
+	palette color.Palette
+
+	blend map[BlendMode]*BlendTable
+	fog   map[fogColor]*fogTable
+	heat  *heatTable
+	shade *ShadeTable
+}
+
+// tableCache resolves the palette dependent tables of draw calls. It is
+// owned by the geometry goroutine, so building a table never blocks the
+// submitting goroutines.
+type tableCache struct {
+	// Most recently used first.
+	palettes []*paletteTables
+}
+
+func (c *tableCache) lookup(pal color.Palette) *paletteTables {
+	id := idOf(pal)
+	for i, t := range c.palettes {
+		if t.id == id {
+			copy(c.palettes[1:i+1], c.palettes[:i])
+			c.palettes[0] = t
+			return t
+		}
+	}
+
+	t := &paletteTables{
+		id:      id,
+		palette: pal,
+		blend:   make(map[BlendMode]*BlendTable),
+		fog:     make(map[fogColor]*fogTable),
+	}
+	if len(c.palettes) < maxPaletteTables {
+		c.palettes = append(c.palettes, nil)
+	}
+	copy(c.palettes[1:], c.palettes)
+	c.palettes[0] = t
+	return t
+}
+
+// resolve sets the tables of dc that are built from the palette of its
+// render target.
+func (c *tableCache) resolve(dc *drawCall) {
+	defaultShade := dc.normals != nil && dc.shadeTable == nil
+	if dc.blendMode == BlendNone && dc.fog.Mode == FogNone && dc.debugMode != DebugOverdraw && !defaultShade {
+		return
+	}
+
+	t := c.lookup(dc.target.Image.Palette)
+
+	if dc.blendMode != BlendNone {
+		if dc.blend = t.blend[dc.blendMode]; dc.blend == nil {
+			dc.blend = NewBlendTable(t.palette, dc.blendMode)
+			t.blend[dc.blendMode] = dc.blend
+		}
+	}
+
+	if dc.fog.Mode != FogNone {
+		dc.fogTable = t.fogTable(dc.fog.Color)
+	}
+
+	if dc.debugMode == DebugOverdraw {
+		if t.heat == nil {
+			t.heat = newHeatTable(t.palette)
+		}
+		dc.heat = t.heat
+	}
+
+	if defaultShade {
+		if t.shade == nil {
+			t.shade = NewShadeTable(t.palette, defaultShadeLevels, defaultShadeBrightest)
+		}
+		dc.shadeTable = t.shade
+	}
+}
+
+// fogTable returns the fog table of c, evicting an arbitrary one if there
+// are too many.
+func (t *paletteTables) fogTable(c color.Color) *fogTable {
+	r, g, b, a := c.RGBA()
+	key := fogColor{r, g, b, a}
+
+	table, ok := t.fog[key]
+	if !ok {
+		if len(t.fog) >= maxFogTables {
+			for k := range t.fog {
+				delete(t.fog, k)
+				break
+			}
+		}
+
+		table = newFogTable(t.palette, c)
+		t.fog[key] = table
+	}
+	return table
+}
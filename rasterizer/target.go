@@ -0,0 +1,58 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+package rasterizer
+
+import (
+	"image"
+	"math"
+)
+
+// RenderTarget is an image draw calls can be rendered into, with an
+// optional depth buffer of its own.
+type RenderTarget struct {
+	Image *image.Paletted
+	depth []float32
+	spans *spanBuffer
+	heat  heatMap
+}
+
+// NewRenderTarget wraps img as a render target. If depth is set the target
+// gets a depth buffer cleared to +Inf.
+func NewRenderTarget(img *image.Paletted, depth bool) *RenderTarget {
+	rt := &RenderTarget{Image: img}
+	if depth {
+		rt.depth = make([]float32, len(img.Pix))
+		fillDepth(rt.depth, float32(math.Inf(1)))
+	}
+	return rt
+}
+
+// SetRenderTarget redirects draw calls submitted after the call to rt, or
+// back to the back buffer if rt is nil. The viewport and the clip stack are
+// reset to the bounds of the new target.
+//
+// Everything submitted before the switch is rasterized before anything
+// submitted after it, so the image of a target can be used as a texture by
+// later draw calls. Building a mipmapped texture from it on the CPU still
+// requires waiting for the draw calls that render into it.
+func (r *Rasterizer) SetRenderTarget(rt *RenderTarget) {
+	if rt == nil {
+		rt = r.backBuffer
+	}
+
+	r.submitMutex.Lock()
+	r.target = rt
+	r.viewport = rt.Image.Bounds()
+	r.clips = clipStack{bounds: rt.Image.Bounds()}
+	r.submitMutex.Unlock()
+}
+
+// RenderTarget returns the target draw calls are currently rendered into.
+func (r *Rasterizer) RenderTarget() *RenderTarget {
+	r.submitMutex.Lock()
+	defer r.submitMutex.Unlock()
+	return r.target
+}
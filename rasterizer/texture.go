@@ -0,0 +1,171 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+package rasterizer
+
+import (
+	"image"
+	"math"
+)
+
+// Texture is a chain of paletted mip levels. Level 0 is the full size
+// image and every following level is half the size of the previous one.
+type Texture struct {
+	levels []*image.Paletted
+}
+
+// NewTexture builds a full mip chain from img, down to a single texel.
+// Every level is filtered in RGB from the previous one and quantized back to
+// the palette of img.
+func NewTexture(img *image.Paletted) *Texture {
+	t := &Texture{levels: []*image.Paletted{img}}
+	quantized := make(map[[3]uint8]uint8)
+
+	for src := img; src.Rect.Dx() > 1 || src.Rect.Dy() > 1; {
+		src = downsample(src, quantized)
+		t.levels = append(t.levels, src)
+	}
+	return t
+}
+
+// NewTextureLevels creates a texture from a prebuilt mip chain. A single
+// level gives a texture without mipmaps.
+func NewTextureLevels(levels ...*image.Paletted) *Texture {
+	return &Texture{levels: levels}
+}
+
+// Levels returns the number of mip levels.
+func (t *Texture) Levels() int {
+	return len(t.levels)
+}
+
+// empty reports if the texture has no levels or a level without texels.
+func (t *Texture) empty() bool {
+	for _, level := range t.levels {
+		if level == nil || level.Rect.Empty() {
+			return true
+		}
+	}
+	return len(t.levels) == 0
+}
+
+// Level returns mip level i, clamped to the available levels.
+func (t *Texture) Level(i int) *image.Paletted {
+	if i < 0 {
+		i = 0
+	} else if i >= len(t.levels) {
+		i = len(t.levels) - 1
+	}
+	return t.levels[i]
+}
+
+// Sample point samples the mip level closest to lod.
+func (t *Texture) Sample(s *Sampler, u, v, lod float32) (uint8, bool) {
+	return s.Sample(t.Level(int(lod+0.5)), u, v)
+}
+
+// lod returns the level of detail for texture coordinates changing by
+// dudx, dvdx along x and dudy, dvdy along y, in units of the full texture.
+func (t *Texture) lod(dudx, dvdx, dudy, dvdy float32) float32 {
+	size := t.levels[0].Rect.Size()
+	w, h := float32(size.X), float32(size.Y)
+
+	dx := (dudx*w)*(dudx*w) + (dvdx*h)*(dvdx*h)
+	dy := (dudy*w)*(dudy*w) + (dvdy*h)*(dvdy*h)
+
+	rho := dx
+	if dy > rho {
+		rho = dy
+	}
+	if rho <= 1 {
+		return 0
+	}
+
+	// log2 of the square root of rho.
+	return float32(math.Log2(float64(rho))) * 0.5
+}
+
+func downsample(src *image.Paletted, quantized map[[3]uint8]uint8) *image.Paletted {
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	dw, dh := maxInt(sw/2, 1), maxInt(sh/2, 1)
+	dst := image.NewPaletted(image.Rect(0, 0, dw, dh), src.Palette)
+
+	var rgb [256][3]uint32
+	for i, c := range src.Palette {
+		r, g, b, _ := c.RGBA()
+		rgb[i] = [3]uint32{r >> 8, g >> 8, b >> 8}
+	}
+
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sum [3]uint32
+
+			for _, o := range [...]image.Point{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+				sx := minInt(x*2+o.X, sw-1)
+				sy := minInt(y*2+o.Y, sh-1)
+				c := &rgb[src.Pix[sy*src.Stride+sx]]
+
+				sum[0] += c[0]
+				sum[1] += c[1]
+				sum[2] += c[2]
+			}
+
+			key := [3]uint8{uint8((sum[0] + 2) / 4), uint8((sum[1] + 2) / 4), uint8((sum[2] + 2) / 4)}
+			index, ok := quantized[key]
+			if !ok {
+				index = nearestIndex(src.Palette, key[0], key[1], key[2])
+				quantized[key] = index
+			}
+			dst.Pix[y*dst.Stride+x] = index
+		}
+	}
+	return dst
+}
+
+// textureGradients computes the screen space gradients of the texture
+// coordinate varyings of a projected triangle, used for mip level selection.
+func textureGradients(tri *triangle) {
+	v0, v1, v2 := &tri.v[0], &tri.v[1], &tri.v[2]
+
+	ex1, ey1 := v1.x-v0.x, v1.y-v0.y
+	ex2, ey2 := v2.x-v0.x, v2.y-v0.y
+
+	det := ex1*ey2 - ex2*ey1
+	if det == 0 {
+		tri.grad = [3][2]float32{}
+		return
+	}
+	inv := 1 / det
+
+	for i, k := range [...]int{varyingU, varyingV, varyingW} {
+		d1 := v1.varyings[k] - v0.varyings[k]
+		d2 := v2.varyings[k] - v0.varyings[k]
+
+		tri.grad[i][0] = (d1*ey2 - d2*ey1) * inv
+		tri.grad[i][1] = (d2*ex1 - d1*ex2) * inv
+	}
+}
+
+// spanLOD returns the level of detail at the center of a span with the end
+// point varyings sda and eda.
+func spanLOD(tri *triangle, sda, eda *varyings) float32 {
+	var mid varyings
+	lerpVaryings(&mid, sda, eda, 0.5)
+
+	g := &tri.grad
+	if tri.dc.mapping == MappingAffine {
+		return tri.dc.texture.lod(g[0][0], g[1][0], g[0][1], g[1][1])
+	}
+
+	// Derivatives of u = U/W, where U and W are linear in screen space.
+	w := 1 / mid[varyingW]
+	u := mid[varyingU] * w
+	v := mid[varyingV] * w
+
+	return tri.dc.texture.lod(
+		(g[0][0]-u*g[2][0])*w, (g[1][0]-v*g[2][0])*w,
+		(g[0][1]-u*g[2][1])*w, (g[1][1]-v*g[2][1])*w,
+	)
+}
@@ -0,0 +1,36 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+package rasterizer_test
+
+import (
+	"image"
+	"image/color/palette"
+	"testing"
+
+	"github.com/andreas-jonsson/drive/rasterizer"
+	"github.com/ungerik/go3d/vec2"
+	"github.com/ungerik/go3d/vec3"
+)
+
+// TestEmptyTexture checks that draw calls with an empty texture draw
+// nothing and still retire.
+func TestEmptyTexture(t *testing.T) {
+	empty := image.NewPaletted(image.Rect(0, 0, 0, 8), palette.Plan9)
+	vert := []vec3.T{{4, 4, 0}, {60, 4, 0}, {4, 60, 0}}
+	uvs := []vec2.T{{0, 0}, {1, 0}, {0, 1}}
+
+	img := render(t, nil, func(r *rasterizer.Rasterizer) {
+		sampler := rasterizer.Sampler{AddressU: rasterizer.AddressRepeat, AddressV: rasterizer.AddressMirror}
+		r.DrawTextured(pixelSpace(), vert, uvs, empty, rasterizer.WithSampler(sampler))
+		r.DrawMipmapped(pixelSpace(), vert, uvs, rasterizer.NewTextureLevels(checkerboard(8, 2), empty))
+	})
+
+	for i, index := range img.Pix {
+		if index != 0 {
+			t.Fatalf("pixel %d,%d is %d, want 0", i%goldenWidth, i/goldenWidth, index)
+		}
+	}
+}
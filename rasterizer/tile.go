@@ -0,0 +1,249 @@
+// +-------------------=D=r=i=v=e=-=E=n=g=i=n=e=---------------------+
+// | Copyright (C) 2016-2017 Andreas T Jonsson. All rights reserved. |
+// | Contact <mail@andreasjonsson.se>                                |
+// +-----------------------------------------------------------------+
+
+package rasterizer
+
+import (
+	"image"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	tileSize     = 32
+	maxBatchSize = 4 * triangleBufferSize
+)
+
+type tile struct {
+	bounds    image.Rectangle
+	triangles []int
+}
+
+// binner buckets triangles into screen tiles. Triangles are collected into
+// a batch that is flushed to the worker pool when it is full or when the
+// pipeline runs dry. Each tile is owned by exactly one worker during a flush
+// and keeps its triangles in submission order, so the output is identical to
+// rasterizing the batch on a single goroutine.
+//
+// A batch only ever targets one render target. Switching target flushes the
+// batch, so a target is complete before later draw calls can sample it.
+type binner struct {
+	r       *Rasterizer
+	target  *RenderTarget
+	bounds  image.Rectangle
+	tiles   []tile
+	columns int
+
+	batch    []triangle
+	fence    uint64
+	hasFence bool
+
+	tileChan chan *tile
+	flushWG  sync.WaitGroup
+
+	// Start of the current period of binning, excluding flushes and
+	// waiting for triangles.
+	binStart time.Time
+}
+
+func newBinner(r *Rasterizer, numWorkers int) *binner {
+	b := &binner{
+		r:        r,
+		batch:    make([]triangle, 0, maxBatchSize),
+		tileChan: make(chan *tile, numWorkers),
+		binStart: time.Now(),
+	}
+	b.setTarget(r.backBuffer)
+	return b
+}
+
+// setTarget flushes the current batch and rebuilds the tile grid for rt if
+// its bounds differ from the previous target.
+func (b *binner) setTarget(rt *RenderTarget) {
+	if rt == b.target {
+		return
+	}
+
+	b.flush()
+	b.target = rt
+
+	if b.r.spanBuffer && rt.spans == nil {
+		rt.spans = newSpanBuffer(rt.Image.Bounds())
+	}
+
+	bounds := rt.Image.Bounds()
+	if bounds == b.bounds && b.tiles != nil {
+		return
+	}
+
+	b.bounds = bounds
+	b.tiles = b.tiles[:0]
+	b.columns = (bounds.Dx() + tileSize - 1) / tileSize
+	rows := (bounds.Dy() + tileSize - 1) / tileSize
+
+	for y := 0; y < rows; y++ {
+		for x := 0; x < b.columns; x++ {
+			min := bounds.Min.Add(image.Pt(x*tileSize, y*tileSize))
+			rect := image.Rectangle{min, min.Add(image.Pt(tileSize, tileSize))}
+			b.tiles = append(b.tiles, tile{bounds: rect.Intersect(bounds)})
+		}
+	}
+}
+
+func (b *binner) worker() {
+	for t := range b.tileChan {
+		start := time.Now()
+
+		var visited, written int
+		for _, i := range t.triangles {
+			v, w := b.r.rasterize(t.bounds, &b.batch[i])
+			visited, written = visited+v, written+w
+		}
+
+		b.r.stats.addRaster(visited, written, time.Since(start))
+		b.flushWG.Done()
+	}
+}
+
+// rasterize draws the part of tri that falls inside the tile bounds and
+// returns the number of pixels it visited and wrote.
+func (r *Rasterizer) rasterize(bounds image.Rectangle, tri *triangle) (visited, written int) {
+	clip := bounds.Intersect(tri.dc.clip)
+
+	// Blits are 2D and are not affected by the viewport.
+	if tri.prim == primBlit {
+		written = drawBlit(tri.dc.target.Image, clip, tri.dc.blit)
+		return written, written
+	}
+
+	clip = clip.Intersect(tri.dc.viewport)
+
+	switch {
+	case tri.prim == primLine:
+		return r.rasterizeLine(clip, tri)
+	case tri.prim == primPoint:
+		return r.rasterizePoint(clip, tri)
+	case r.edgeFunctions:
+		return r.rasterizeEdge(clip, tri)
+	default:
+		return r.rasterizeScanline(clip, tri)
+	}
+}
+
+func (b *binner) bin(tri *triangle) {
+	minX, maxX := tri.v[0].x, tri.v[0].x
+	minY, maxY := tri.v[0].y, tri.v[0].y
+
+	for _, v := range tri.v[1:] {
+		if v.x < minX {
+			minX = v.x
+		} else if v.x > maxX {
+			maxX = v.x
+		}
+		if v.y < minY {
+			minY = v.y
+		} else if v.y > maxY {
+			maxY = v.y
+		}
+	}
+
+	// Spans are inclusive of the truncated end coordinate, hence the +1.
+	rect := image.Rect(int(minX), int(minY), int(maxX)+1, int(maxY)+1).Intersect(b.bounds)
+	if rect.Empty() {
+		return
+	}
+
+	index := len(b.batch)
+	b.batch = append(b.batch, *tri)
+
+	rect = rect.Sub(b.bounds.Min)
+	for y := rect.Min.Y / tileSize; y <= (rect.Max.Y-1)/tileSize; y++ {
+		for x := rect.Min.X / tileSize; x <= (rect.Max.X-1)/tileSize; x++ {
+			t := &b.tiles[y*b.columns+x]
+			t.triangles = append(t.triangles, index)
+		}
+	}
+
+	if len(b.batch) == maxBatchSize {
+		b.flush()
+	}
+}
+
+// flush rasterizes all binned triangles and retires the last fence seen.
+func (b *binner) flush() {
+	addDuration(&b.r.stats.BinTime, time.Since(b.binStart))
+
+	for i := range b.tiles {
+		if t := &b.tiles[i]; len(t.triangles) > 0 {
+			b.flushWG.Add(1)
+			b.tileChan <- t
+		}
+	}
+	b.flushWG.Wait()
+
+	for i := range b.tiles {
+		b.tiles[i].triangles = b.tiles[i].triangles[:0]
+	}
+	atomic.AddUint64(&b.r.stats.Primitives, uint64(len(b.batch)))
+	if b.target != nil && b.target.spans != nil {
+		atomic.AddUint64(&b.r.stats.PixelsHidden, uint64(b.target.spans.takeHidden()))
+	}
+	b.batch = b.batch[:0]
+
+	if b.hasFence {
+		b.r.retire(b.fence)
+		b.hasFence = false
+	}
+	b.binStart = time.Now()
+}
+
+func (b *binner) run(triangleChan <-chan triangle) {
+	for {
+		var (
+			tri triangle
+			ok  bool
+		)
+
+		// Flush whenever the geometry stage has nothing more queued,
+		// otherwise keep batching.
+		select {
+		case tri, ok = <-triangleChan:
+		default:
+			b.flush()
+			tri, ok = <-triangleChan
+			b.binStart = time.Now()
+		}
+
+		if !ok {
+			b.flush()
+			close(b.tileChan)
+			return
+		}
+
+		switch tri.cmd {
+		case cmdFence:
+			b.fence = tri.id
+			b.hasFence = true
+		case cmdDraw:
+			b.setTarget(tri.dc.target)
+			if tri.dc.debugMode == DebugOverdraw && b.target.heat == nil {
+				b.target.heat = make(heatMap, len(b.target.Image.Pix))
+			}
+			b.bin(&tri)
+		case cmdClearDepth:
+			b.flush()
+			if depth := tri.dc.target.depth; depth != nil {
+				fillDepth(depth, tri.dc.depthClear)
+			}
+			if spans := tri.dc.target.spans; spans != nil {
+				spans.clear()
+			}
+			if heat := tri.dc.target.heat; heat != nil {
+				heat.clear()
+			}
+		}
+	}
+}